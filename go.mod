@@ -3,6 +3,7 @@ module scrapping
 go 1.24.0
 
 require (
+	github.com/andybalholm/cascadia v1.3.3
 	github.com/go-rod/rod v0.116.2
 	github.com/gobwas/ws v1.3.2
 	github.com/gofiber/contrib/websocket v1.3.3
@@ -12,6 +13,7 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/sirupsen/logrus v1.9.3
 	github.com/xuri/excelize/v2 v2.9.0
+	golang.org/x/net v0.35.0
 )
 
 require (
@@ -41,7 +43,6 @@ require (
 	github.com/ysmood/gson v0.7.3 // indirect
 	github.com/ysmood/leakless v0.9.0 // indirect
 	golang.org/x/crypto v0.35.0 // indirect
-	golang.org/x/net v0.35.0 // indirect
 	golang.org/x/sys v0.30.0 // indirect
 	golang.org/x/text v0.22.0 // indirect
 )
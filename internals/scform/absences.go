@@ -0,0 +1,71 @@
+package scform
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/go-rod/rod"
+	"golang.org/x/net/html"
+)
+
+// dateRegex finds a dd/mm/yyyy date in the text of a field
+var dateRegex = regexp.MustCompile(`\d{2}/\d{2}/\d{4}`)
+
+// parseAbsences reads the absences listed on the absences page. Rows without a date,
+// like the header of the table, are skipped.
+func parseAbsences(doc *html.Node, profile *LayoutProfile) []Absence {
+	sel := profile.absences
+	if sel == nil {
+		return nil
+	}
+
+	text := func(row *html.Node, field *cssSelector) string {
+		if field == nil {
+			return ""
+		}
+		return strings.TrimSpace(selectText(row, field))
+	}
+
+	var absences []Absence
+	for _, row := range findAll(doc, sel.row.match) {
+		date := parseDate(dateRegex.FindString(text(row, sel.date)))
+		if date.IsZero() {
+			continue
+		}
+
+		absence := Absence{
+			Date:   date,
+			Slot:   text(row, sel.slot),
+			Course: text(row, sel.course),
+			Reason: text(row, sel.reason),
+		}
+		if sel.justifiedText != nil {
+			absence.Justified = sel.justifiedText.MatchString(text(row, sel.justified))
+		}
+		if sel.lateText != nil {
+			absence.Late = sel.lateText.MatchString(text(row, sel.kind))
+		}
+		absences = append(absences, absence)
+	}
+
+	if len(absences) == 0 {
+		warnEmptyPage(profile, profile.Absences.Page, "absence")
+	}
+	return absences
+}
+
+// fetchAbsences opens the absences page of the profile in the browser and parses it
+func fetchAbsences(page *rod.Page, homeURL string, profile *LayoutProfile) ([]Absence, error) {
+	if profile.absences == nil {
+		return nil, nil
+	}
+
+	doc, err := openProfilePage(page, homeURL, profile.Absences.Page, StepAbsences)
+	if err != nil {
+		return nil, err
+	}
+
+	absences := parseAbsences(doc, profile)
+	DebugLog("Found %d absences", len(absences))
+	return absences, nil
+}
@@ -0,0 +1,111 @@
+package scform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// Kinds of login challenges
+const (
+	ChallengeCaptcha       = "captcha"
+	ChallengeEmailCode     = "email_code"
+	ChallengeConfirmDevice = "confirm_device"
+)
+
+const (
+	// challengeTimeout bounds how long the user has to answer a challenge
+	challengeTimeout = 3 * time.Minute
+	// maxChallenges stops a login showing challenge after challenge
+	maxChallenges = 3
+)
+
+// ErrChallengeUnanswered is the cause of a login stopped at a challenge the user did not answer,
+// wrapped in an ErrInvalidCredentials StepError so it is not retried
+var ErrChallengeUnanswered = errors.New("login challenge not answered")
+
+// Challenge is an interstitial SC-Connect shows during the login, like a captcha, a code sent by
+// email or a device confirmation, that only the user can get past
+type Challenge struct {
+	Kind        string // One of the Challenge* kinds
+	NeedsAnswer bool   // Whether an answer must be typed, or the challenge only needs confirming
+	Screenshot  []byte // PNG screenshot of the page showing the challenge
+}
+
+// ChallengeSolver asks the user to answer a challenge and returns the answer, or an error if the
+// user refused it or ctx is done first
+type ChallengeSolver func(ctx context.Context, challenge Challenge) (string, error)
+
+// reloadImagesJS loads again the images of the page that failed to load, like a captcha blocked by
+// the request filter before the challenge was detected, resolving once they all loaded or failed
+const reloadImagesJS = `() => Promise.all(Array.from(document.images)
+	.filter((img) => img.src && (!img.complete || img.naturalWidth === 0))
+	.map((img) => new Promise((resolve) => {
+		img.addEventListener('load', resolve, { once: true });
+		img.addEventListener('error', resolve, { once: true });
+		const src = img.src;
+		img.src = '';
+		img.src = src;
+	})))`
+
+// solveChallenge relays the challenge shown on page to the solver and submits the answer
+func solveChallenge(ctx context.Context, page *rod.Page, layout ChallengeLayout, solve ChallengeSolver) error {
+	if solve == nil {
+		return &StepError{Step: StepLogin, Kind: ErrInvalidCredentials, Err: fmt.Errorf("%w: %s", ErrChallengeUnanswered, layout.Kind)}
+	}
+	DebugLog("Login shows a %s challenge, waiting for the user", layout.Kind)
+
+	// The challenge is only shown once its images are there
+	if _, err := page.Timeout(5 * time.Second).Eval(reloadImagesJS); err != nil {
+		DebugLog("Failed to reload the challenge images: %v", err)
+	}
+
+	screenshot, err := page.Timeout(10*time.Second).Screenshot(false, &proto.PageCaptureScreenshot{
+		Format: proto.PageCaptureScreenshotFormatPng,
+	})
+	if err != nil {
+		return stepError(StepLogin, ErrBrowserUnavailable, err)
+	}
+
+	solveCtx, cancel := context.WithTimeout(ctx, challengeTimeout)
+	defer cancel()
+	answer, err := solve(solveCtx, Challenge{
+		Kind:        layout.Kind,
+		NeedsAnswer: layout.Input != "",
+		Screenshot:  screenshot,
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &StepError{Step: StepLogin, Kind: ErrInvalidCredentials, Err: fmt.Errorf("%w: %v", ErrChallengeUnanswered, err)}
+	}
+
+	if layout.Input != "" {
+		input, err := page.Timeout(5 * time.Second).Element(layout.Input)
+		if err != nil {
+			return stepError(StepLogin, ErrLayoutChanged, err)
+		}
+		if err := input.Input(strings.TrimSpace(answer)); err != nil {
+			return stepError(StepLogin, ErrLayoutChanged, err)
+		}
+	}
+	submit, err := page.Timeout(5 * time.Second).Element(layout.Submit)
+	if err != nil {
+		return stepError(StepLogin, ErrLayoutChanged, err)
+	}
+	if err := submit.Click(proto.InputMouseButtonLeft, 1); err != nil {
+		return stepError(StepLogin, ErrLayoutChanged, err)
+	}
+
+	// Give SC-Connect the time to take the answer before looking at the page again
+	if err := page.Timeout(10 * time.Second).WaitStable(time.Second); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		DebugLog("Page did not settle after the challenge: %v", err)
+	}
+	return nil
+}
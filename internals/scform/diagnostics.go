@@ -0,0 +1,437 @@
+package scform
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"scrapping/internals/utils"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// diagnosticIDRegex matches the short IDs given to diagnostic bundles
+var diagnosticIDRegex = regexp.MustCompile(`^[A-Za-z0-9]{8}$`)
+
+const (
+	// redactedText replaces the password wherever it shows up in a bundle
+	redactedText = "[REDACTED]"
+	// redactedUser replaces the username wherever it shows up in a bundle
+	redactedUser = "[USER]"
+)
+
+// DiagnosticStore keeps the diagnostic bundles captured on failed retrieval attempts, one zip per bundle
+type DiagnosticStore struct {
+	Dir         string // Where the bundles are written
+	MaxBundles  int    // Bundles kept, the oldest are removed beyond it
+	CapturePage bool   // Whether bundles include the page HTML and a screenshot, which show the student's data
+
+	mu sync.Mutex
+}
+
+// DiagnosticBundle describes a failed retrieval attempt
+type DiagnosticBundle struct {
+	ID             string           `json:"id"`
+	CreatedAt      time.Time        `json:"createdAt"`
+	User           string           `json:"user"` // Hash of the username, see userKey
+	URL            string           `json:"url"`
+	Error          string           `json:"error"`
+	Step           string           `json:"step,omitempty"`
+	Steps          []StepTiming     `json:"steps"`
+	Tabs           []string         `json:"tabs"`
+	Console        []ConsoleMessage `json:"console"`
+	FailedRequests []FailedRequest  `json:"failedRequests"`
+
+	HTML       string `json:"-"` // Saved as page.html
+	Screenshot []byte `json:"-"` // Saved as screenshot.png
+}
+
+// StepTiming is how long a scraping step took
+type StepTiming struct {
+	Step       string    `json:"step"`
+	Start      time.Time `json:"start"`
+	DurationMs int64     `json:"durationMs"`
+}
+
+// ConsoleMessage is a message logged to the browser console, or an uncaught exception
+type ConsoleMessage struct {
+	Time  time.Time `json:"time"`
+	Level string    `json:"level"`
+	Text  string    `json:"text"`
+}
+
+// FailedRequest is a network request that failed or got an error status
+type FailedRequest struct {
+	Time   time.Time `json:"time"`
+	URL    string    `json:"url"`
+	Status int       `json:"status,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// diagnosedError is a retrieval error with the ID of the bundle captured for it
+type diagnosedError struct {
+	id  string
+	err error
+}
+
+func (e *diagnosedError) Error() string { return fmt.Sprintf("%v (diagnostic %s)", e.err, e.id) }
+func (e *diagnosedError) Unwrap() error { return e.err }
+
+// DiagnosticID returns the ID of the diagnostic bundle captured for err, or "" if there is none
+func DiagnosticID(err error) string {
+	var diagnosed *diagnosedError
+	if errors.As(err, &diagnosed) {
+		return diagnosed.id
+	}
+	return ""
+}
+
+// NewDiagnosticStoreFromEnv creates a store in SCFORM_DIAGNOSTICS_DIR keeping SCFORM_DIAGNOSTICS_MAX_BUNDLES
+// bundles, with the page HTML and screenshot unless SCFORM_DIAGNOSTICS_CAPTURE_PAGE is false. It returns
+// nil, disabling the capture, when the maximum is 0.
+func NewDiagnosticStoreFromEnv() *DiagnosticStore {
+	maxBundles := envInt("SCFORM_DIAGNOSTICS_MAX_BUNDLES", 100)
+	if maxBundles <= 0 {
+		return nil
+	}
+
+	dir := os.Getenv("SCFORM_DIAGNOSTICS_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "scform-diagnostics")
+	}
+	capturePage := true
+	if value := os.Getenv("SCFORM_DIAGNOSTICS_CAPTURE_PAGE"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Printf("Invalid SCFORM_DIAGNOSTICS_CAPTURE_PAGE %q, capturing pages", value)
+		} else {
+			capturePage = parsed
+		}
+	}
+	return &DiagnosticStore{Dir: dir, MaxBundles: maxBundles, CapturePage: capturePage}
+}
+
+// Path returns the zip file of a bundle, or an error if the ID is invalid or unknown
+func (s *DiagnosticStore) Path(id string) (string, error) {
+	if !diagnosticIDRegex.MatchString(id) {
+		return "", fmt.Errorf("invalid diagnostic id %q", id)
+	}
+	path := filepath.Join(s.Dir, id+".zip")
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Save writes a bundle as a zip under a new short ID, returning the ID
+func (s *DiagnosticStore) Save(bundle *DiagnosticBundle) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create diagnostics directory: %v", err)
+	}
+	// The default directory is in the shared temp directory, where someone else may have created it
+	if err := os.Chmod(s.Dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to restrict diagnostics directory: %v", err)
+	}
+
+	id, err := utils.CreateShortLink(8)
+	if err != nil {
+		return "", err
+	}
+	bundle.ID = id
+
+	// Write to a temporary file first so a download never sees a partial zip
+	tmp, err := os.CreateTemp(s.Dir, id+"-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := writeBundleZip(tmp, bundle); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.Dir, id+".zip")); err != nil {
+		return "", err
+	}
+
+	s.prune()
+	return id, nil
+}
+
+// writeBundleZip writes the summary, the page HTML and the screenshot of a bundle
+func writeBundleZip(f *os.File, bundle *DiagnosticBundle) error {
+	zw := zip.NewWriter(f)
+
+	summary, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"summary.json", summary},
+		{"page.html", []byte(bundle.HTML)},
+		{"screenshot.png", bundle.Screenshot},
+	}
+	for _, file := range files {
+		if len(file.data) == 0 {
+			continue
+		}
+		w, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(file.data); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// prune removes the oldest bundles beyond MaxBundles, s.mu must be held
+func (s *DiagnosticStore) prune() {
+	paths, err := filepath.Glob(filepath.Join(s.Dir, "*.zip"))
+	if err != nil || len(paths) <= s.MaxBundles {
+		return
+	}
+
+	modTimes := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			modTimes[path] = info.ModTime()
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool { return modTimes[paths[i]].Before(modTimes[paths[j]]) })
+
+	for _, path := range paths[:len(paths)-s.MaxBundles] {
+		if err := os.Remove(path); err != nil {
+			DebugLog("Failed to remove old diagnostic bundle %s: %v", path, err)
+		}
+	}
+}
+
+// diagnosticRecorder collects what happens during one retrieval attempt so a bundle can be
+// captured if it fails. A nil recorder, used when diagnostics are disabled, records nothing.
+type diagnosticRecorder struct {
+	store *DiagnosticStore
+	creds Credentials
+
+	mu       sync.Mutex
+	page     *rod.Page
+	steps    []StepTiming
+	console  []ConsoleMessage
+	failed   []FailedRequest
+	requests map[proto.NetworkRequestID]string // URLs of the requests in flight
+}
+
+// recorder starts recording a retrieval attempt, it returns nil if the store is nil
+func (s *DiagnosticStore) recorder(creds Credentials) *diagnosticRecorder {
+	if s == nil {
+		return nil
+	}
+	return &diagnosticRecorder{
+		store:    s,
+		creds:    creds,
+		requests: make(map[proto.NetworkRequestID]string),
+	}
+}
+
+// step records the start of a scraping step, ending the previous one
+func (r *diagnosticRecorder) step(name string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.endStep()
+	r.steps = append(r.steps, StepTiming{Step: name, Start: time.Now(), DurationMs: -1})
+}
+
+// endStep sets the duration of the step in progress, r.mu must be held
+func (r *diagnosticRecorder) endStep() {
+	if n := len(r.steps); n > 0 && r.steps[n-1].DurationMs < 0 {
+		r.steps[n-1].DurationMs = time.Since(r.steps[n-1].Start).Milliseconds()
+	}
+}
+
+// watch makes page the one captured on failure and records its console and failed requests until ctx ends
+func (r *diagnosticRecorder) watch(ctx context.Context, page *rod.Page) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	watched := r.page != nil && r.page.TargetID == page.TargetID
+	r.page = page
+	r.mu.Unlock()
+	if watched {
+		return
+	}
+
+	wait := page.Context(ctx).EachEvent(
+		func(e *proto.RuntimeConsoleAPICalled) {
+			args := make([]string, 0, len(e.Args))
+			for _, arg := range e.Args {
+				if arg.Value.Nil() {
+					args = append(args, arg.Description)
+				} else {
+					args = append(args, arg.Value.Str())
+				}
+			}
+			r.addConsole(string(e.Type), strings.Join(args, " "))
+		},
+		func(e *proto.RuntimeExceptionThrown) {
+			text := e.ExceptionDetails.Text
+			if e.ExceptionDetails.Exception != nil && e.ExceptionDetails.Exception.Description != "" {
+				text += " " + e.ExceptionDetails.Exception.Description
+			}
+			r.addConsole("exception", text)
+		},
+		func(e *proto.NetworkRequestWillBeSent) {
+			r.mu.Lock()
+			r.requests[e.RequestID] = e.Request.URL
+			r.mu.Unlock()
+		},
+		func(e *proto.NetworkResponseReceived) {
+			if e.Response.Status >= 400 {
+				r.addFailedRequest(FailedRequest{URL: e.Response.URL, Status: e.Response.Status})
+			}
+		},
+		func(e *proto.NetworkLoadingFailed) {
+			r.mu.Lock()
+			requestURL := r.requests[e.RequestID]
+			r.mu.Unlock()
+			r.addFailedRequest(FailedRequest{URL: requestURL, Error: e.ErrorText})
+		},
+	)
+	go wait()
+}
+
+// addConsole records a console message
+func (r *diagnosticRecorder) addConsole(level, text string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.console = append(r.console, ConsoleMessage{Time: time.Now(), Level: level, Text: text})
+}
+
+// addFailedRequest records a failed request
+func (r *diagnosticRecorder) addFailedRequest(req FailedRequest) {
+	req.Time = time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failed = append(r.failed, req)
+}
+
+// fail captures a bundle for the error the attempt failed with and returns the error tagged
+// with the bundle ID. Cancellations are not diagnosed, and err is returned as is if the bundle
+// cannot be saved. browser may be nil when none was obtained.
+func (r *diagnosticRecorder) fail(browser *rod.Browser, err error) error {
+	if r == nil || err == nil || errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	r.mu.Lock()
+	r.endStep()
+	bundle := &DiagnosticBundle{
+		CreatedAt:      time.Now(),
+		User:           userKey(r.creds.Username),
+		URL:            r.redact(r.creds.URL),
+		Error:          r.redact(err.Error()),
+		Step:           FailedStep(err),
+		Steps:          append([]StepTiming(nil), r.steps...),
+		Console:        make([]ConsoleMessage, 0, len(r.console)),
+		FailedRequests: make([]FailedRequest, 0, len(r.failed)),
+	}
+	for _, message := range r.console {
+		message.Text = r.redact(message.Text)
+		bundle.Console = append(bundle.Console, message)
+	}
+	for _, req := range r.failed {
+		req.URL = r.redact(req.URL)
+		bundle.FailedRequests = append(bundle.FailedRequests, req)
+	}
+	page := r.page
+	r.mu.Unlock()
+
+	// The retrieval context may be over, the capture runs on its own short deadline
+	if browser != nil {
+		if tabs, tabsErr := contextPages(browser.Context(context.Background()).Timeout(5 * time.Second)); tabsErr == nil {
+			for _, tab := range tabs {
+				if info, infoErr := tab.Info(); infoErr == nil {
+					bundle.Tabs = append(bundle.Tabs, r.redact(info.URL))
+				}
+			}
+		}
+	}
+	if page != nil && r.store.CapturePage {
+		page = page.Context(context.Background())
+		if pageHTML, htmlErr := page.Timeout(5 * time.Second).HTML(); htmlErr == nil {
+			bundle.HTML = r.redact(pageHTML)
+		} else {
+			DebugLog("Failed to capture page HTML: %v", htmlErr)
+		}
+		if screenshot, shotErr := page.Timeout(10*time.Second).Screenshot(true, &proto.PageCaptureScreenshot{
+			Format: proto.PageCaptureScreenshotFormatPng,
+		}); shotErr == nil {
+			bundle.Screenshot = screenshot
+		} else {
+			DebugLog("Failed to capture screenshot: %v", shotErr)
+		}
+	}
+
+	id, saveErr := r.store.Save(bundle)
+	if saveErr != nil {
+		log.Printf("Failed to save diagnostic bundle: %v", saveErr)
+		return err
+	}
+	log.Printf("Saved diagnostic bundle %s for user %s: %v", id, bundle.User, bundle.Error)
+	return &diagnosedError{id: id, err: err}
+}
+
+// redact removes the password and the username from text, in their plain, URL-encoded and HTML-escaped forms
+func (r *diagnosticRecorder) redact(text string) string {
+	for _, secret := range []struct{ value, replacement string }{
+		{r.creds.Password, redactedText},
+		{r.creds.Username, redactedUser},
+	} {
+		if secret.value == "" {
+			continue
+		}
+		for _, form := range []string{secret.value, url.QueryEscape(secret.value), url.PathEscape(secret.value), html.EscapeString(secret.value)} {
+			text = strings.ReplaceAll(text, form, secret.replacement)
+		}
+	}
+	return text
+}
+
+// userKey identifies a user in bundles and logs without their username: the first
+// 12 hex digits of its SHA-256, which an admin can compute from a username to find its bundles
+func userKey(username string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(username))))
+	return hex.EncodeToString(sum[:])[:12]
+}
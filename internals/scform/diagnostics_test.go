@@ -0,0 +1,115 @@
+package scform
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiagnosticRecorderRedact(t *testing.T) {
+	r := &diagnosticRecorder{creds: Credentials{
+		URL:      "https://sc.example/login",
+		Username: "jane.doe@example.com",
+		Password: "p&ss w/rd<1>",
+	}}
+
+	tests := []struct {
+		name, text, want string
+	}{
+		{"plain password", "login failed for p&ss w/rd<1>", "login failed for [REDACTED]"},
+		{"query escaped password", "POST /login?password=p%26ss+w%2Frd%3C1%3E", "POST /login?password=[REDACTED]"},
+		{"path escaped password", "/auth/p&ss%20w%2Frd%3C1%3E", "/auth/[REDACTED]"},
+		{"html escaped password", `<input value="p&amp;ss w/rd&lt;1&gt;">`, `<input value="[REDACTED]">`},
+		{"plain username", "Bienvenue jane.doe@example.com", "Bienvenue [USER]"},
+		{"query escaped username", "/login?email=jane.doe%40example.com", "/login?email=[USER]"},
+		{"both", "jane.doe@example.com:p&ss w/rd<1>", "[USER]:[REDACTED]"},
+		{"nothing to redact", "Timeout waiting for MesNotes.aspx", "Timeout waiting for MesNotes.aspx"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.redact(tt.text); got != tt.want {
+				t.Errorf("redact(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+
+	empty := &diagnosticRecorder{}
+	if got := empty.redact("unchanged"); got != "unchanged" {
+		t.Errorf("redact without credentials = %q", got)
+	}
+}
+
+func TestDiagnosticRecorderFail(t *testing.T) {
+	creds := Credentials{URL: "https://sc.example/login", Username: "jane.doe@example.com", Password: "secret"}
+
+	tests := []struct {
+		name     string
+		err      error
+		captured bool
+	}{
+		{"timeout", stepErrorf(StepNavigate, ErrTimeout, "no grades page for jane.doe@example.com"), true},
+		{"layout changed", stepErrorf(StepExtract, ErrLayoutChanged, "no course table"), true},
+		{"invalid credentials", classifyLoginMessage("Identifiant ou mot de passe incorrect"), true},
+		{"password expired", classifyLoginMessage("Votre mot de passe a expiré"), true},
+		{"cancelled", context.Canceled, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &DiagnosticStore{Dir: filepath.Join(t.TempDir(), "diagnostics"), MaxBundles: 10}
+			r := store.recorder(creds)
+			r.step(StepLogin)
+
+			err := r.fail(nil, tt.err)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("fail returned %v, want it to wrap %v", err, tt.err)
+			}
+			id := DiagnosticID(err)
+			if (id != "") != tt.captured {
+				t.Fatalf("diagnostic id %q, captured want %v", id, tt.captured)
+			}
+			if !tt.captured {
+				return
+			}
+
+			path, err := store.Path(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info, err := os.Stat(store.Dir); err != nil || info.Mode().Perm() != 0o700 {
+				t.Errorf("diagnostics directory mode %v (%v), want 0700", info.Mode().Perm(), err)
+			}
+
+			zr, err := zip.OpenReader(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer zr.Close()
+			if len(zr.File) != 1 || zr.File[0].Name != "summary.json" {
+				t.Fatalf("bundle files %v, want summary.json only", zr.File)
+			}
+			f, err := zr.File[0].Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			data, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			summary := string(data)
+			if strings.Contains(summary, creds.Username) {
+				t.Errorf("summary shows the username: %s", summary)
+			}
+			if !strings.Contains(summary, userKey(creds.Username)) {
+				t.Errorf("summary misses the user key: %s", summary)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package scform
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+	"golang.org/x/net/html"
+)
+
+const (
+	// maxDocumentSize is the size above which a document is not downloaded
+	maxDocumentSize = 20 << 20
+	// postbackDownloadTimeout bounds how long a LinkButton takes to start and finish its download
+	postbackDownloadTimeout = 30 * time.Second
+)
+
+// documentLink is a document listed on the documents page
+type documentLink struct {
+	Name     string
+	URL      string // File URL, empty for a postback
+	Postback bool   // ASP.NET LinkButton, whose click posts the page back and gets the file as a download
+	Index    int    // Position of the link among the matches of the profile selector, to click it
+}
+
+// findDocumentLinks returns the documents linked from the documents page, resolved against its URL.
+// LinkButtons running __doPostBack are returned as postbacks, other links running a script are skipped.
+func findDocumentLinks(doc *html.Node, profile *LayoutProfile, pageURL *url.URL) []documentLink {
+	if profile.documents == nil {
+		return nil
+	}
+
+	var links []documentLink
+	seen := make(map[string]bool)
+	for i, a := range findAll(doc, profile.documents.match) {
+		href := strings.TrimSpace(attr(a, "href"))
+		if strings.HasPrefix(strings.ToLower(href), "javascript:") && strings.Contains(href, "__doPostBack") {
+			if !seen[href] {
+				seen[href] = true
+				link := documentLink{Name: textContent(a), Postback: true, Index: i}
+				if link.Name == "" {
+					link.Name = "document"
+				}
+				links = append(links, link)
+			}
+			continue
+		}
+		if href == "" || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+			DebugLog("Skipping document link without a file URL: %q", textContent(a))
+			continue
+		}
+		ref, err := url.Parse(href)
+		if err != nil {
+			continue
+		}
+
+		link := documentLink{Name: textContent(a), URL: pageURL.ResolveReference(ref).String(), Index: i}
+		if seen[link.URL] {
+			continue
+		}
+		seen[link.URL] = true
+		if link.Name == "" {
+			link.Name = path.Base(ref.Path)
+		}
+		links = append(links, link)
+	}
+
+	return links
+}
+
+// newDocument builds a downloaded document, naming its file after the Content-Disposition
+// header, or the last segment of its URL when it is a file
+func newDocument(link documentLink, data []byte, contentType, disposition string) Document {
+	document := Document{
+		Name:        link.Name,
+		ContentType: contentType,
+		Size:        len(data),
+		Data:        data,
+	}
+
+	if _, params, err := mime.ParseMediaType(disposition); err == nil && params["filename"] != "" {
+		document.FileName = path.Base(params["filename"])
+	} else if u, err := url.Parse(link.URL); err == nil && isDocumentExt(path.Ext(u.Path)) {
+		document.FileName = path.Base(u.Path)
+	} else {
+		// Served by a handler, the file is named after the link
+		document.FileName = link.Name
+		if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+			if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+				document.FileName += exts[0]
+			}
+		}
+	}
+	if document.ContentType == "" {
+		document.ContentType = "application/octet-stream"
+	}
+	return document
+}
+
+// isDocumentExt reports whether a URL extension names a file rather than an ASP.NET handler
+func isDocumentExt(ext string) bool {
+	switch strings.ToLower(ext) {
+	case "", ".aspx", ".ashx", ".axd":
+		return false
+	}
+	return true
+}
+
+// fetchDocuments opens the documents page of the profile in the browser and downloads every
+// document through the logged in page, clicking the LinkButtons. A document failing to download is left out.
+func fetchDocuments(page *rod.Page, homeURL string, profile *LayoutProfile) ([]Document, error) {
+	if profile.documents == nil {
+		return nil, nil
+	}
+
+	doc, err := openProfilePage(page, homeURL, profile.Documents.Page, StepDocuments)
+	if err != nil {
+		return nil, err
+	}
+	info, err := page.Info()
+	if err != nil {
+		return nil, stepError(StepDocuments, ErrBrowserUnavailable, err)
+	}
+	pageURL, err := url.Parse(info.URL)
+	if err != nil {
+		return nil, stepError(StepDocuments, ErrLayoutChanged, err)
+	}
+
+	var documents []Document
+	for _, link := range findDocumentLinks(doc, profile, pageURL) {
+		var document Document
+		if link.Postback {
+			document, err = downloadPostback(page, profile, link)
+		} else {
+			document, err = fetchDocument(page, link)
+		}
+		if err != nil {
+			log.Printf("Failed to download document %s: %v", link.Name, err)
+			continue
+		}
+		documents = append(documents, document)
+	}
+
+	if len(documents) == 0 {
+		warnEmptyPage(profile, profile.Documents.Page, "document")
+	}
+	DebugLog("Downloaded %d documents", len(documents))
+	return documents, nil
+}
+
+// fetchDocument downloads the file of a link through the page, so the request carries the session cookies
+func fetchDocument(page *rod.Page, link documentLink) (Document, error) {
+	res, err := page.Eval(`async (url, maxSize) => {
+		const response = await fetch(url, { credentials: 'include' });
+		if (!response.ok) {
+			throw new Error('unexpected status ' + response.status);
+		}
+		const buffer = await response.arrayBuffer();
+		if (buffer.byteLength > maxSize) {
+			throw new Error('document larger than ' + maxSize + ' bytes');
+		}
+		const bytes = new Uint8Array(buffer);
+		let binary = '';
+		for (let i = 0; i < bytes.length; i += 0x8000) {
+			binary += String.fromCharCode.apply(null, bytes.subarray(i, i + 0x8000));
+		}
+		return {
+			data: btoa(binary),
+			contentType: response.headers.get('Content-Type') || '',
+			disposition: response.headers.get('Content-Disposition') || '',
+		};
+	}`, link.URL, maxDocumentSize)
+	if err != nil {
+		return Document{}, err
+	}
+
+	var file struct {
+		Data        string `json:"data"`
+		ContentType string `json:"contentType"`
+		Disposition string `json:"disposition"`
+	}
+	if err := res.Value.Unmarshal(&file); err != nil {
+		return Document{}, fmt.Errorf("failed to read document: %v", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(file.Data)
+	if err != nil {
+		return Document{}, fmt.Errorf("failed to decode document: %v", err)
+	}
+	return newDocument(link, data, file.ContentType, file.Disposition), nil
+}
+
+// downloadPostback clicks a LinkButton of the documents page and reads the file its postback downloads
+func downloadPostback(page *rod.Page, profile *LayoutProfile, link documentLink) (Document, error) {
+	links, err := page.Timeout(5 * time.Second).Elements(profile.Documents.Link)
+	if err != nil {
+		return Document{}, err
+	}
+	if link.Index >= len(links) {
+		return Document{}, fmt.Errorf("link no longer on the page")
+	}
+
+	dir, err := os.MkdirTemp("", "scform-download-*")
+	if err != nil {
+		return Document{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithTimeout(page.GetContext(), postbackDownloadTimeout)
+	defer cancel()
+	browser := page.Browser()
+	wait := browser.Context(ctx).WaitDownload(dir)
+	// WaitDownload restores the download behavior with its own context, which may be over by then
+	defer func() {
+		_ = proto.BrowserSetDownloadBehavior{
+			Behavior:         proto.BrowserSetDownloadBehaviorBehaviorDefault,
+			BrowserContextID: browser.BrowserContextID,
+		}.Call(browser.Context(context.Background()).Timeout(5 * time.Second))
+	}()
+
+	if err := links[link.Index].Context(ctx).Click(proto.InputMouseButtonLeft, 1); err != nil {
+		cancel()
+		wait()
+		return Document{}, err
+	}
+	info := wait()
+	if ctx.Err() != nil || info == nil {
+		return Document{}, fmt.Errorf("no download within %s", postbackDownloadTimeout)
+	}
+
+	file := filepath.Join(dir, info.GUID)
+	if stat, err := os.Stat(file); err != nil {
+		return Document{}, err
+	} else if stat.Size() > maxDocumentSize {
+		return Document{}, fmt.Errorf("document larger than %d bytes", maxDocumentSize)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return Document{}, err
+	}
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": info.SuggestedFilename})
+	return newDocument(link, data, mime.TypeByExtension(path.Ext(info.SuggestedFilename)), disposition), nil
+}
@@ -0,0 +1,38 @@
+package scform
+
+import "testing"
+
+func TestNewDocument(t *testing.T) {
+	data := []byte("%PDF-1.4")
+
+	tests := []struct {
+		name        string
+		link        documentLink
+		contentType string
+		disposition string
+		fileName    string
+		wantType    string
+	}{
+		{"file URL", documentLink{Name: "Bulletin", URL: "https://sc.example/Fichiers/Bulletin_S1.pdf"}, "application/pdf", "", "Bulletin_S1.pdf", "application/pdf"},
+		{"content disposition", documentLink{Name: "Attestation", URL: "https://sc.example/Document.ashx?id=42"}, "application/pdf", `attachment; filename="attestation 2024.pdf"`, "attestation 2024.pdf", "application/pdf"},
+		{"handler without disposition", documentLink{Name: "Attestation", URL: "https://sc.example/Document.ashx?id=42"}, "application/pdf", "", "Attestation.pdf", "application/pdf"},
+		{"unknown type", documentLink{Name: "Relevé", URL: "https://sc.example/Telecharger.aspx"}, "", "", "Relevé", "application/octet-stream"},
+		{"postback download", documentLink{Name: "Relevé de notes", Postback: true}, "application/pdf", `attachment; filename="releve.pdf"`, "releve.pdf", "application/pdf"},
+		{"path in the disposition", documentLink{Name: "Relevé", URL: "https://sc.example/Document.ashx"}, "application/pdf", `attachment; filename="../../etc/passwd"`, "passwd", "application/pdf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document := newDocument(tt.link, data, tt.contentType, tt.disposition)
+			if document.FileName != tt.fileName {
+				t.Errorf("file name %q, want %q", document.FileName, tt.fileName)
+			}
+			if document.ContentType != tt.wantType {
+				t.Errorf("content type %q, want %q", document.ContentType, tt.wantType)
+			}
+			if document.Name != tt.link.Name || document.Size != len(data) {
+				t.Errorf("got %+v", document)
+			}
+		})
+	}
+}
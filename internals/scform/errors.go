@@ -0,0 +1,87 @@
+package scform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Error kinds returned by the grade sources, test them with errors.Is
+var (
+	ErrBrowserUnavailable = errors.New("browser unavailable")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrLayoutChanged      = errors.New("SCForm layout changed")
+	ErrTimeout            = errors.New("timed out")
+	ErrUpstreamDown       = errors.New("SCForm unavailable")
+)
+
+// Steps of a grade retrieval, reported in StepError
+const (
+	StepConnect     = "connect"
+	StepOpenLogin   = "open_login"
+	StepLogin       = "login"
+	StepNavigate    = "navigate_grades"
+	StepDisplayMode = "display_mode"
+	StepExtract     = "extract_grades"
+	StepAbsences    = "fetch_absences"
+	StepTimetable   = "fetch_timetable"
+	StepDocuments   = "fetch_documents"
+)
+
+// StepError is the error returned when a step of a grade retrieval fails.
+// It matches both its Kind and its underlying cause with errors.Is.
+type StepError struct {
+	Step string // Step that failed, one of the Step* constants
+	Kind error  // One of the Err* kinds
+	Err  error  // Underlying cause, may be nil
+}
+
+func (e *StepError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s: %v", e.Step, e.Kind)
+	}
+	return fmt.Sprintf("%s: %v: %v", e.Step, e.Kind, e.Err)
+}
+
+// Unwrap exposes both the kind and the cause to errors.Is and errors.As
+func (e *StepError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// stepError wraps err for the given step. Deadlines are reported as ErrTimeout, except while
+// waiting for an element where they mean it is missing, and cancellations are returned
+// untouched so callers can tell them apart.
+func stepError(step string, kind error, err error) error {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return err
+	case kind == ErrLayoutChanged:
+	case errors.Is(err, context.DeadlineExceeded), isNetTimeout(err):
+		kind = ErrTimeout
+	}
+	return &StepError{Step: step, Kind: kind, Err: err}
+}
+
+// stepErrorf builds a StepError from a formatted cause
+func stepErrorf(step string, kind error, format string, args ...interface{}) error {
+	return &StepError{Step: step, Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+// FailedStep returns the step a grade retrieval failed at, or "" if err is not a StepError
+func FailedStep(err error) string {
+	var stepErr *StepError
+	if errors.As(err, &stepErr) {
+		return stepErr.Step
+	}
+	return ""
+}
+
+// isNetTimeout reports whether err is a network timeout
+func isNetTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -1,8 +1,13 @@
 package scform
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/xuri/excelize/v2"
 )
@@ -28,10 +33,12 @@ func ExportToExcel(student *Student) (*excelize.File, error) {
 	f.SetColWidth(sheetName, "E", "E", 15) // Type
 	f.SetColWidth(sheetName, "F", "F", 10) // Value
 	f.SetColWidth(sheetName, "G", "G", 10) // OutOf
-	f.SetColWidth(sheetName, "H", "H", 12) // Coefficient
-	f.SetColWidth(sheetName, "I", "I", 30) // Remarks
-	f.SetColWidth(sheetName, "J", "J", 30) // Observation
-	f.SetColWidth(sheetName, "K", "K", 15) // Module Average
+	f.SetColWidth(sheetName, "H", "H", 15) // Normalized
+	f.SetColWidth(sheetName, "I", "I", 12) // Coefficient
+	f.SetColWidth(sheetName, "J", "J", 30) // Remarks
+	f.SetColWidth(sheetName, "K", "K", 30) // Observation
+	f.SetColWidth(sheetName, "L", "L", 15) // Module Average
+	f.SetColWidth(sheetName, "M", "M", 12) // Status
 
 	// Create header style
 	headerStyle, err := f.NewStyle(&excelize.Style{
@@ -106,10 +113,12 @@ func ExportToExcel(student *Student) (*excelize.File, error) {
 		"Type",
 		"Value",
 		"Out Of",
+		fmt.Sprintf("Normalized (/%g)", GradeScale()),
 		"Coefficient",
 		"Remarks",
 		"Observation",
 		"Module Average",
+		"Status",
 	}
 
 	// Write headers
@@ -131,6 +140,12 @@ func ExportToExcel(student *Student) (*excelize.File, error) {
 				dateStr = grade.Date.Format("02/01/2006")
 			}
 
+			// Grades without a mark leave their value cells empty rather than showing a 0
+			var value, normalized interface{} = "", ""
+			if grade.Status == GradeGraded {
+				value, normalized = grade.Value, grade.Normalized()
+			}
+
 			// Prepare row data
 			rowData := []interface{}{
 				student.Name,
@@ -138,12 +153,14 @@ func ExportToExcel(student *Student) (*excelize.File, error) {
 				grade.Title,
 				dateStr,
 				grade.Type,
-				grade.Value,
+				value,
 				grade.OutOf,
+				normalized,
 				grade.Coefficient,
 				grade.Remarks,
 				grade.Observation,
 				course.Average,
+				grade.Status.Label(),
 			}
 
 			// Write row data with appropriate styles
@@ -151,8 +168,8 @@ func ExportToExcel(student *Student) (*excelize.File, error) {
 				cell, _ := excelize.CoordinatesToCellName(col+1, currentRow)
 				f.SetCellValue(sheetName, cell, value)
 
-				// Apply number style to numeric columns (Value, OutOf, Coefficient, Module Average)
-				if col == 5 || col == 6 || col == 7 || col == 10 {
+				// Apply number style to numeric columns (Value, OutOf, Normalized, Coefficient, Module Average)
+				if col == 5 || col == 6 || col == 7 || col == 8 || col == 11 {
 					f.SetCellStyle(sheetName, cell, cell, numberStyle)
 				} else {
 					f.SetCellStyle(sheetName, cell, cell, dataStyle)
@@ -194,5 +211,147 @@ func ExportToExcel(student *Student) (*excelize.File, error) {
 	f.SetCellStyle(sheetName, fmt.Sprintf("A%d", summaryRow), fmt.Sprintf("A%d", summaryRow), summaryStyle)
 	f.SetCellStyle(sheetName, fmt.Sprintf("B%d", summaryRow), fmt.Sprintf("B%d", summaryRow), summaryStyle)
 
+	// List the average of each grading period below the total
+	for i, period := range student.Periods {
+		row := summaryRow + 1 + i
+		f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), period.Name)
+		f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), period.Average)
+		f.SetCellStyle(sheetName, fmt.Sprintf("A%d", row), fmt.Sprintf("B%d", row), summaryStyle)
+	}
+
+	// Then the details of the student, when the home page showed them
+	profileRow := summaryRow + len(student.Periods) + 2
+	for _, detail := range [][2]string{
+		{"Formation", student.Profile.Formation},
+		{"Class", student.Profile.Class},
+		{"Training Centre", student.Profile.Centre},
+		{"School Year", student.Profile.SchoolYear},
+	} {
+		if detail[1] == "" {
+			continue
+		}
+		f.SetCellValue(sheetName, fmt.Sprintf("A%d", profileRow), detail[0])
+		f.SetCellValue(sheetName, fmt.Sprintf("B%d", profileRow), detail[1])
+		f.SetCellStyle(sheetName, fmt.Sprintf("A%d", profileRow), fmt.Sprintf("A%d", profileRow), summaryStyle)
+		f.SetCellStyle(sheetName, fmt.Sprintf("B%d", profileRow), fmt.Sprintf("B%d", profileRow), dataStyle)
+		profileRow++
+	}
+
+	// Absences get a sheet of their own
+	if len(student.Absences) > 0 {
+		if err := writeAbsencesSheet(f, student.Absences, headerStyle, dataStyle); err != nil {
+			return nil, fmt.Errorf("failed to write absences: %v", err)
+		}
+	}
+
 	return f, nil
 }
+
+// writeAbsencesSheet adds an Absences sheet listing every absence and lateness
+func writeAbsencesSheet(f *excelize.File, absences []Absence, headerStyle, dataStyle int) error {
+	const sheetName = "Absences"
+	if _, err := f.NewSheet(sheetName); err != nil {
+		return err
+	}
+
+	f.SetColWidth(sheetName, "A", "A", 15) // Date
+	f.SetColWidth(sheetName, "B", "B", 20) // Slot
+	f.SetColWidth(sheetName, "C", "C", 30) // Course
+	f.SetColWidth(sheetName, "D", "D", 12) // Type
+	f.SetColWidth(sheetName, "E", "E", 12) // Justified
+	f.SetColWidth(sheetName, "F", "F", 40) // Reason
+
+	headers := []string{"Date", "Slot", "Course", "Type", "Justified", "Reason"}
+	for col, header := range headers {
+		cell, _ := excelize.CoordinatesToCellName(col+1, 1)
+		f.SetCellValue(sheetName, cell, header)
+		f.SetCellStyle(sheetName, cell, cell, headerStyle)
+	}
+
+	for i, absence := range absences {
+		kind := "Absence"
+		if absence.Late {
+			kind = "Lateness"
+		}
+		justified := "No"
+		if absence.Justified {
+			justified = "Yes"
+		}
+
+		rowData := []interface{}{
+			absence.Date.Format("02/01/2006"),
+			absence.Slot,
+			absence.Course,
+			kind,
+			justified,
+			absence.Reason,
+		}
+		for col, value := range rowData {
+			cell, _ := excelize.CoordinatesToCellName(col+1, i+2)
+			f.SetCellValue(sheetName, cell, value)
+			f.SetCellStyle(sheetName, cell, cell, dataStyle)
+		}
+	}
+
+	return nil
+}
+
+// icsTimeFormat is the UTC date-time format of iCalendar
+const icsTimeFormat = "20060102T150405Z"
+
+// icsTextEscaper escapes the characters iCalendar reserves in text values
+var icsTextEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// ExportToICS converts the timetable of a student to an iCalendar file. Event UIDs only depend
+// on the course and start, so calendar apps polling the file update their events even when
+// the name of the student, read from the home page, changes.
+func ExportToICS(student *Student) []byte {
+	var b strings.Builder
+	line := func(content string) {
+		writeICSLine(&b, content)
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//scform//Emploi du temps//FR")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	line("X-WR-CALNAME:" + icsTextEscaper.Replace("Emploi du temps "+student.Name))
+
+	stamp := time.Now().UTC().Format(icsTimeFormat)
+	for _, entry := range student.Timetable {
+		uid := sha1.Sum([]byte(entry.Course + "|" + entry.Start.UTC().Format(icsTimeFormat)))
+
+		line("BEGIN:VEVENT")
+		line("UID:" + hex.EncodeToString(uid[:]) + "@scform")
+		line("DTSTAMP:" + stamp)
+		line("DTSTART:" + entry.Start.UTC().Format(icsTimeFormat))
+		line("DTEND:" + entry.End.UTC().Format(icsTimeFormat))
+		line("SUMMARY:" + icsTextEscaper.Replace(entry.Course))
+		if entry.Room != "" {
+			line("LOCATION:" + icsTextEscaper.Replace(entry.Room))
+		}
+		if entry.Teacher != "" {
+			line("DESCRIPTION:" + icsTextEscaper.Replace("Formateur : "+entry.Teacher))
+		}
+		line("END:VEVENT")
+	}
+
+	line("END:VCALENDAR")
+	return []byte(b.String())
+}
+
+// writeICSLine writes a content line, folded every 75 octets without splitting a character
+func writeICSLine(b *strings.Builder, content string) {
+	width := 0
+	for _, r := range content {
+		size := utf8.RuneLen(r)
+		if width+size > 75 {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += size
+	}
+	b.WriteString("\r\n")
+}
@@ -0,0 +1,127 @@
+package scform
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestExportToICS(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	maths := TimetableEntry{
+		Start:   time.Date(2025, time.January, 6, 8, 30, 0, 0, paris),
+		End:     time.Date(2025, time.January, 6, 12, 30, 0, 0, paris),
+		Course:  "Mathématiques",
+		Room:    "B204",
+		Teacher: "M. Martin",
+	}
+	student := &Student{Name: "Jane Doe", Timetable: []TimetableEntry{maths}}
+
+	tests := []struct {
+		name  string
+		entry TimetableEntry
+		want  []string // Unfolded lines of the event
+	}{
+		{
+			name:  "session",
+			entry: maths,
+			want: []string{
+				"DTSTART:20250106T073000Z",
+				"DTEND:20250106T113000Z",
+				"SUMMARY:Mathématiques",
+				"LOCATION:B204",
+				"DESCRIPTION:Formateur : M. Martin",
+			},
+		},
+		{
+			name: "reserved characters",
+			entry: TimetableEntry{
+				Start:  time.Date(2025, time.March, 30, 9, 0, 0, 0, paris),
+				End:    time.Date(2025, time.March, 30, 17, 15, 0, 0, paris),
+				Course: `Anglais; oral, écrit \ TOEIC`,
+			},
+			want: []string{
+				"DTSTART:20250330T070000Z",
+				"DTEND:20250330T151500Z",
+				`SUMMARY:Anglais\; oral\, écrit \\ TOEIC`,
+			},
+		},
+		{
+			name: "long line",
+			entry: TimetableEntry{
+				Start:  maths.Start,
+				End:    maths.End,
+				Course: strings.Repeat("Économie générale et droit ", 5),
+			},
+			want: []string{
+				"SUMMARY:" + strings.Repeat("Économie générale et droit ", 5),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ics := string(ExportToICS(&Student{Name: "Jane Doe", Timetable: []TimetableEntry{tt.entry}}))
+
+			if !strings.HasPrefix(ics, "BEGIN:VCALENDAR\r\n") || !strings.HasSuffix(ics, "END:VCALENDAR\r\n") {
+				t.Errorf("not a calendar:\n%s", ics)
+			}
+			for _, line := range strings.Split(strings.TrimSuffix(ics, "\r\n"), "\r\n") {
+				if len(line) > 75 {
+					t.Errorf("line of %d octets: %q", len(line), line)
+				}
+				if !utf8.ValidString(line) {
+					t.Errorf("character split by folding: %q", line)
+				}
+			}
+
+			unfolded := strings.Split(strings.ReplaceAll(ics, "\r\n ", ""), "\r\n")
+			for _, want := range tt.want {
+				found := false
+				for _, line := range unfolded {
+					found = found || line == want
+				}
+				if !found {
+					t.Errorf("missing %q in\n%s", want, ics)
+				}
+			}
+		})
+	}
+
+	// UIDs follow the course and start, not the student name
+	uid := icsUIDs(t, ExportToICS(student))
+	renamed := *student
+	renamed.Name = "DOE Jane"
+	if got := icsUIDs(t, ExportToICS(&renamed)); got[0] != uid[0] {
+		t.Errorf("UID changed with the student name: %s, was %s", got[0], uid[0])
+	}
+	moved := maths
+	moved.Start = moved.Start.Add(time.Hour)
+	other := maths
+	other.Course = "Anglais"
+	changed := icsUIDs(t, ExportToICS(&Student{Name: student.Name, Timetable: []TimetableEntry{moved, other}}))
+	for _, got := range changed {
+		if got == uid[0] {
+			t.Errorf("UID %s kept by another session", got)
+		}
+	}
+}
+
+// icsUIDs returns the UIDs of the events of a calendar
+func icsUIDs(t *testing.T, ics []byte) []string {
+	t.Helper()
+	var uids []string
+	for _, line := range strings.Split(string(ics), "\r\n") {
+		if uid, ok := strings.CutPrefix(line, "UID:"); ok {
+			uids = append(uids, uid)
+		}
+	}
+	if len(uids) == 0 {
+		t.Fatalf("no event in\n%s", ics)
+	}
+	return uids
+}
@@ -0,0 +1,83 @@
+package scform
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-rod/rod"
+	"golang.org/x/net/html"
+)
+
+// extractGrades reads the courses and the periods of the grades page shown in the browser, with
+// the first of the profiles whose course tables are found. It returns a nil profile if none matches.
+// The page is fetched once and parsed in Go like a saved one, so a missing span costs no round trip.
+func extractGrades(page *rod.Page, profiles []*LayoutProfile) ([]rawCourse, []periodOption, *LayoutProfile, error) {
+	started := time.Now()
+
+	pageHTML, err := page.Timeout(10 * time.Second).HTML()
+	if err != nil {
+		return nil, nil, nil, stepError(StepExtract, ErrBrowserUnavailable, err)
+	}
+	doc, err := html.Parse(strings.NewReader(pageHTML))
+	if err != nil {
+		return nil, nil, nil, stepErrorf(StepExtract, ErrLayoutChanged, "failed to parse grades page: %v", err)
+	}
+
+	for _, profile := range profiles {
+		courses := extractRawCourses(doc, profile)
+		if len(courses) == 0 {
+			continue
+		}
+		_, periods := findPeriods(doc, profile)
+
+		grades := 0
+		for _, course := range courses {
+			grades += len(course.Grades)
+		}
+		log.Printf("Extracted %d courses and %d grades with layout profile %s in %s", len(courses), grades, profile.Name, time.Since(started).Round(time.Millisecond))
+
+		return courses, periods, profile, nil
+	}
+	return nil, nil, nil, nil
+}
+
+// stepTimer measures how long the steps of a retrieval take, logging them once it is done
+type stepTimer struct {
+	username string
+	steps    []StepTiming
+}
+
+// step starts timing a step, ending the previous one
+func (t *stepTimer) step(name string) {
+	t.end()
+	t.steps = append(t.steps, StepTiming{Step: name, Start: time.Now(), DurationMs: -1})
+}
+
+// end sets the duration of the step in progress
+func (t *stepTimer) end() {
+	if n := len(t.steps); n > 0 && t.steps[n-1].DurationMs < 0 {
+		t.steps[n-1].DurationMs = time.Since(t.steps[n-1].Start).Milliseconds()
+	}
+}
+
+// log writes the duration of every step on a single line
+func (t *stepTimer) log(err error) {
+	t.end()
+	if len(t.steps) == 0 {
+		return
+	}
+
+	parts := make([]string, 0, len(t.steps))
+	for _, step := range t.steps {
+		parts = append(parts, fmt.Sprintf("%s %dms", step.Step, step.DurationMs))
+	}
+	total := time.Since(t.steps[0].Start).Round(time.Millisecond)
+
+	outcome := "succeeded"
+	if err != nil {
+		outcome = "failed"
+	}
+	log.Printf("Retrieval for %s %s in %s: %s", userKey(t.username), outcome, total, strings.Join(parts, ", "))
+}
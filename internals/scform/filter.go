@@ -0,0 +1,289 @@
+package scform
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// defaultDeniedDomains are the analytics and advertising domains blocked unless configured otherwise
+var defaultDeniedDomains = []string{
+	"google-analytics.com",
+	"googletagmanager.com",
+	"doubleclick.net",
+	"hotjar.com",
+	"clarity.ms",
+	"facebook.net",
+}
+
+// RequestFilter blocks the requests a retrieval does not need, like images and analytics, so the
+// slow SCForm pages load faster. It runs in the browser through request hijacking, so it works the
+// same for local and remote browsers.
+type RequestFilter struct {
+	ResourceTypes   []proto.NetworkResourceType // Types of resources blocked, like Image or Font
+	DeniedDomains   []string                    // Domains blocked, subdomains included
+	AllowedDomains  []string                    // Domains never blocked, subdomains included
+	BlockThirdParty bool                        // Block the domains no page of the retrieval was loaded from
+}
+
+// NewRequestFilterFromEnv creates a filter from SCFORM_BLOCK_RESOURCES, SCFORM_BLOCK_DOMAINS,
+// SCFORM_ALLOW_DOMAINS and SCFORM_BLOCK_THIRD_PARTY. It returns nil if it would block nothing.
+func NewRequestFilterFromEnv() *RequestFilter {
+	filter := &RequestFilter{
+		ResourceTypes:   []proto.NetworkResourceType{proto.NetworkResourceTypeImage, proto.NetworkResourceTypeFont, proto.NetworkResourceTypeMedia},
+		DeniedDomains:   defaultDeniedDomains,
+		AllowedDomains:  envList("SCFORM_ALLOW_DOMAINS"),
+		BlockThirdParty: os.Getenv("SCFORM_BLOCK_THIRD_PARTY") == "true",
+	}
+
+	if value, ok := os.LookupEnv("SCFORM_BLOCK_RESOURCES"); ok {
+		filter.ResourceTypes = nil
+		for _, name := range envList("SCFORM_BLOCK_RESOURCES") {
+			if resourceType, ok := resourceTypeNamed(name); ok {
+				filter.ResourceTypes = append(filter.ResourceTypes, resourceType)
+			} else if name != "none" {
+				log.Printf("Ignoring unknown resource type %q in SCFORM_BLOCK_RESOURCES %q", name, value)
+			}
+		}
+	}
+	if _, ok := os.LookupEnv("SCFORM_BLOCK_DOMAINS"); ok {
+		filter.DeniedDomains = envList("SCFORM_BLOCK_DOMAINS")
+	}
+
+	if len(filter.ResourceTypes) == 0 && len(filter.DeniedDomains) == 0 && !filter.BlockThirdParty {
+		return nil
+	}
+	return filter
+}
+
+// envList splits a comma-separated environment variable, lower-casing its items
+func envList(name string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// resourceTypeNamed returns the resource type of a name, whatever its case
+func resourceTypeNamed(name string) (proto.NetworkResourceType, bool) {
+	for _, resourceType := range []proto.NetworkResourceType{
+		proto.NetworkResourceTypeImage,
+		proto.NetworkResourceTypeFont,
+		proto.NetworkResourceTypeMedia,
+		proto.NetworkResourceTypeStylesheet,
+		proto.NetworkResourceTypeScript,
+		proto.NetworkResourceTypeTextTrack,
+		proto.NetworkResourceTypeManifest,
+		proto.NetworkResourceTypePing,
+		proto.NetworkResourceTypeCSPViolationReport,
+		proto.NetworkResourceTypeOther,
+	} {
+		if strings.EqualFold(string(resourceType), name) {
+			return resourceType, true
+		}
+	}
+	return "", false
+}
+
+// hostMatches reports whether host is domain or one of its subdomains
+func hostMatches(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// siteOf returns the last two labels of a host, like scform.fr for www.scform.fr
+func siteOf(host string) string {
+	labels := strings.Split(host, ".")
+	if len(labels) <= 2 {
+		return host
+	}
+	return strings.Join(labels[len(labels)-2:], ".")
+}
+
+// filterSession applies a filter to one retrieval and counts what it blocked and loaded. A nil session,
+// used when nothing is filtered, lets every request through.
+type filterSession struct {
+	filter *RequestFilter
+
+	mu          sync.Mutex
+	sites       []string            // Sites pages were loaded from, first party for BlockThirdParty
+	routed      bool                // The requests already go through a browser-wide router
+	routers     []*rod.HijackRouter // Routers of the watched pages
+	requests    int                 // Requests seen
+	blocked     map[string]int      // Requests blocked by reason
+	loadedBytes float64             // Bytes received for the requests let through
+	challenge   bool                // A login challenge is shown, its images are let through
+}
+
+// session starts filtering a retrieval of scformURL, it returns nil if the filter is nil
+func (f *RequestFilter) session(scformURL string) *filterSession {
+	if f == nil {
+		return nil
+	}
+
+	s := &filterSession{filter: f, blocked: make(map[string]int)}
+	if u, err := url.Parse(scformURL); err == nil && u.Hostname() != "" {
+		s.sites = append(s.sites, siteOf(u.Hostname()))
+	}
+	return s
+}
+
+// reason returns why a request is blocked, or "" if it is let through
+func (s *filterSession) reason(resourceType proto.NetworkResourceType, requestURL string) string {
+	u, err := url.Parse(requestURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	host := strings.ToLower(u.Hostname())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Pages are always loaded, and wherever the login redirects to becomes first party
+	if resourceType == proto.NetworkResourceTypeDocument {
+		if site := siteOf(host); !slices.Contains(s.sites, site) {
+			s.sites = append(s.sites, site)
+		}
+		return ""
+	}
+
+	if slices.ContainsFunc(s.filter.AllowedDomains, func(domain string) bool { return hostMatches(host, domain) }) {
+		return ""
+	}
+	if slices.ContainsFunc(s.filter.DeniedDomains, func(domain string) bool { return hostMatches(host, domain) }) {
+		return "denied domain"
+	}
+	// The images of a challenge load wherever they come from, like a captcha from another site
+	if s.challenge && resourceType == proto.NetworkResourceTypeImage {
+		return ""
+	}
+	if slices.Contains(s.filter.ResourceTypes, resourceType) {
+		return strings.ToLower(string(resourceType))
+	}
+	if s.filter.BlockThirdParty && !slices.Contains(s.sites, siteOf(host)) {
+		return "third party"
+	}
+	return ""
+}
+
+// allowImages lets images through for the rest of the retrieval, so a captcha can be shown
+func (s *filterSession) allowImages() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.challenge = true
+	s.mu.Unlock()
+}
+
+// block fails the request if the filter blocks it, reporting whether it did
+func (s *filterSession) block(h *rod.Hijack) bool {
+	reason := s.reason(h.Request.Type(), h.Request.URL().String())
+
+	s.mu.Lock()
+	s.requests++
+	if reason != "" {
+		s.blocked[reason]++
+	}
+	s.mu.Unlock()
+
+	if reason == "" {
+		return false
+	}
+	h.Response.Fail(proto.NetworkErrorReasonBlockedByClient)
+	return true
+}
+
+// wrap returns a hijack handler blocking the filtered requests before handing the others to next,
+// for a browser-wide router whose requests then need no router of their own
+func (s *filterSession) wrap(next func(*rod.Hijack)) func(*rod.Hijack) {
+	if s == nil {
+		return next
+	}
+
+	s.mu.Lock()
+	s.routed = true
+	s.mu.Unlock()
+
+	return func(h *rod.Hijack) {
+		if !s.block(h) {
+			next(h)
+		}
+	}
+}
+
+// watch filters the requests of page and counts the bytes it receives until ctx ends
+func (s *filterSession) watch(ctx context.Context, page *rod.Page) error {
+	if s == nil {
+		return nil
+	}
+
+	go page.Context(ctx).EachEvent(func(e *proto.NetworkLoadingFinished) {
+		s.mu.Lock()
+		s.loadedBytes += e.EncodedDataLength
+		s.mu.Unlock()
+	})()
+
+	s.mu.Lock()
+	routed := s.routed
+	s.mu.Unlock()
+	if routed {
+		return nil
+	}
+
+	// Hijacking the page rather than the browser leaves the other retrievals of a pooled browser alone
+	router := page.Context(ctx).HijackRequests()
+	if err := router.Add("*", "", func(h *rod.Hijack) {
+		if !s.block(h) {
+			h.ContinueRequest(&proto.FetchContinueRequest{})
+		}
+	}); err != nil {
+		return err
+	}
+	go router.Run()
+
+	s.mu.Lock()
+	s.routers = append(s.routers, router)
+	s.mu.Unlock()
+	return nil
+}
+
+// stop stops filtering and logs the requests blocked and the size of those let through.
+// Blocked requests are never sent, so the size they would have had is unknown.
+func (s *filterSession) stop(username string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, router := range s.routers {
+		if err := router.Stop(); err != nil {
+			DebugLog("Failed to stop request filter: %v", err)
+		}
+	}
+	s.routers = nil
+
+	blocked := 0
+	reasons := make([]string, 0, len(s.blocked))
+	for reason, count := range s.blocked {
+		blocked += count
+		reasons = append(reasons, fmt.Sprintf("%d %s", count, reason))
+	}
+	sort.Strings(reasons)
+
+	log.Printf("Request filter for %s blocked %d of %d requests (%s), %.0f KB loaded by the others", userKey(username), blocked, s.requests, strings.Join(reasons, ", "), s.loadedBytes/1024)
+}
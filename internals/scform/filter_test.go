@@ -0,0 +1,101 @@
+package scform
+
+import (
+	"testing"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+func TestRequestFilterReason(t *testing.T) {
+	filter := &RequestFilter{
+		ResourceTypes:   []proto.NetworkResourceType{proto.NetworkResourceTypeImage, proto.NetworkResourceTypeFont},
+		DeniedDomains:   []string{"google-analytics.com", "hotjar.com"},
+		AllowedDomains:  []string{"cdn.scform.fr"},
+		BlockThirdParty: true,
+	}
+
+	// Requests are checked in order, pages loaded earlier make their site first party
+	tests := []struct {
+		name         string
+		resourceType proto.NetworkResourceType
+		url          string
+		want         string
+	}{
+		{"login script", proto.NetworkResourceTypeScript, "https://sc-connect.fr/main.js", ""},
+		{"login image", proto.NetworkResourceTypeImage, "https://sc-connect.fr/logo.png", "image"},
+		{"font", proto.NetworkResourceTypeFont, "https://sc-connect.fr/roboto.woff2", "font"},
+		{"analytics", proto.NetworkResourceTypeScript, "https://www.google-analytics.com/analytics.js", "denied domain"},
+		{"analytics subdomain", proto.NetworkResourceTypeXHR, "https://in.hotjar.com/api", "denied domain"},
+		{"lookalike domain", proto.NetworkResourceTypeScript, "https://nothotjar.com/a.js", "third party"},
+		{"third party", proto.NetworkResourceTypeStylesheet, "https://fonts.googleapis.com/css", "third party"},
+		{"grades script before its page", proto.NetworkResourceTypeScript, "https://www.scform.fr/Eleve/app.js", "third party"},
+		{"grades page", proto.NetworkResourceTypeDocument, "https://www.scform.fr/Eleve/MesNotes.aspx", ""},
+		{"grades script", proto.NetworkResourceTypeScript, "https://www.scform.fr/Eleve/app.js", ""},
+		{"other subdomain of a page site", proto.NetworkResourceTypeXHR, "https://api.scform.fr/notes", ""},
+		{"allowed image", proto.NetworkResourceTypeImage, "https://cdn.scform.fr/captcha.png", ""},
+		{"denied page", proto.NetworkResourceTypeDocument, "https://hotjar.com/", ""},
+		{"data url", proto.NetworkResourceTypeImage, "data:image/png;base64,AAAA", ""},
+	}
+
+	s := filter.session("https://www.sc-connect.fr/login")
+	for _, tt := range tests {
+		if got := s.reason(tt.resourceType, tt.url); got != tt.want {
+			t.Errorf("%s: reason(%s, %s) = %q, want %q", tt.name, tt.resourceType, tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestRequestFilterReasonFirstParty(t *testing.T) {
+	filter := &RequestFilter{DeniedDomains: []string{"doubleclick.net"}}
+	s := filter.session("https://www.sc-connect.fr/login")
+
+	// Without BlockThirdParty, only denied domains are blocked
+	for url, want := range map[string]string{
+		"https://fonts.googleapis.com/css":     "",
+		"https://sc-connect.fr/logo.png":       "",
+		"https://ad.doubleclick.net/pixel.gif": "denied domain",
+	} {
+		if got := s.reason(proto.NetworkResourceTypeImage, url); got != want {
+			t.Errorf("reason(%s) = %q, want %q", url, got, want)
+		}
+	}
+
+	if s := (*RequestFilter)(nil).session("https://www.sc-connect.fr/login"); s != nil {
+		t.Errorf("nil filter gave a session")
+	}
+}
+
+func TestRequestFilterAllowImages(t *testing.T) {
+	filter := &RequestFilter{
+		ResourceTypes:   []proto.NetworkResourceType{proto.NetworkResourceTypeImage, proto.NetworkResourceTypeFont},
+		DeniedDomains:   []string{"doubleclick.net"},
+		BlockThirdParty: true,
+	}
+	s := filter.session("https://www.sc-connect.fr/login")
+
+	captcha := "https://www.sc-connect.fr/api/Captcha?id=1"
+	if got := s.reason(proto.NetworkResourceTypeImage, captcha); got != "image" {
+		t.Fatalf("captcha before the challenge: reason %q, want image", got)
+	}
+
+	s.allowImages()
+	tests := []struct {
+		resourceType proto.NetworkResourceType
+		url          string
+		want         string
+	}{
+		{proto.NetworkResourceTypeImage, captcha, ""},
+		{proto.NetworkResourceTypeImage, "https://www.google.com/recaptcha/api2/payload", ""},
+		{proto.NetworkResourceTypeImage, "https://ad.doubleclick.net/pixel.gif", "denied domain"},
+		{proto.NetworkResourceTypeFont, "https://www.sc-connect.fr/roboto.woff2", "font"},
+		{proto.NetworkResourceTypeScript, "https://www.google.com/recaptcha/api.js", "third party"},
+	}
+	for _, tt := range tests {
+		if got := s.reason(tt.resourceType, tt.url); got != tt.want {
+			t.Errorf("reason(%s, %s) = %q, want %q", tt.resourceType, tt.url, got, tt.want)
+		}
+	}
+
+	// A nil session lets everything through already
+	(*filterSession)(nil).allowImages()
+}
@@ -0,0 +1,54 @@
+package scform
+
+import (
+	"strings"
+
+	"github.com/go-rod/rod"
+	"golang.org/x/net/html"
+)
+
+// parseProfile reads the details of the student shown on the home page. Details the
+// profile has no selector for, or that are not found, are left empty.
+func parseProfile(doc *html.Node, profile *LayoutProfile) Profile {
+	sel := profile.home
+	if sel == nil {
+		return Profile{}
+	}
+
+	text := func(field *cssSelector) string {
+		if field == nil {
+			return ""
+		}
+		// Labels often wrap their value over several lines
+		return strings.Join(strings.Fields(selectText(doc, field)), " ")
+	}
+
+	details := Profile{
+		FullName:   text(sel.fullName),
+		Formation:  text(sel.formation),
+		Class:      text(sel.class),
+		Centre:     text(sel.centre),
+		SchoolYear: text(sel.schoolYear),
+	}
+	if details == (Profile{}) {
+		warnEmptyPage(profile, profile.Login.HomePage, "student detail")
+	}
+	return details
+}
+
+// readProfile parses the home page shown in the browser
+func readProfile(page *rod.Page, profile *LayoutProfile) (Profile, error) {
+	if profile.home == nil {
+		return Profile{}, nil
+	}
+
+	pageHTML, err := page.HTML()
+	if err != nil {
+		return Profile{}, err
+	}
+	doc, err := html.Parse(strings.NewReader(pageHTML))
+	if err != nil {
+		return Profile{}, err
+	}
+	return parseProfile(doc, profile), nil
+}
@@ -0,0 +1,572 @@
+package scform
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+	_ "time/tzdata" // Time zones of the timetables, whatever the host provides
+)
+
+// layoutFileVersion is the version of the layout profile file format understood by this build
+const layoutFileVersion = 1
+
+// defaultLayoutFile holds the built-in profile, which also provides the fields a custom profile leaves out
+//
+//go:embed layouts/default.json
+var defaultLayoutFile []byte
+
+// LayoutProfile describes the markup of one flavour of SCForm: the selectors of the login
+// and grades pages and the text conventions of the grades. Profiles are tried in order,
+// so several SCForm versions can be served by the same deployment.
+type LayoutProfile struct {
+	Name      string          `json:"name"`
+	Login     LoginLayout     `json:"login"`
+	Home      HomeLayout      `json:"home"`
+	Grades    GradesLayout    `json:"grades"`
+	Absences  AbsencesLayout  `json:"absences"`
+	Timetable TimetableLayout `json:"timetable"`
+	Documents DocumentsLayout `json:"documents"`
+
+	// Selectors compiled for the parsed HTML
+	loginPassword *cssSelector // Tells the login form an expired session is sent back to
+
+	courseTable *cssSelector
+	courseName  *cssSelector
+	gradeBlock  *cssSelector
+	fields      gradeFieldSelectors
+	titleDate   *regexp.Regexp
+	periods     *cssSelector   // nil when the profile lists no period
+	periodDates *regexp.Regexp // nil when period names carry no date
+	statusText  map[GradeStatus]*regexp.Regexp
+	home        *homeSelectors // nil when the profile reads nothing from the home page
+	absences    *absenceSelectors
+	timetable   *timetableSelectors
+	documents   *cssSelector // nil when the profile lists no document
+}
+
+// absenceSelectors holds the compiled AbsencesLayout, absent fields being nil
+type absenceSelectors struct {
+	row                                         *cssSelector
+	date, slot, course, justified, reason, kind *cssSelector
+	justifiedText, lateText                     *regexp.Regexp
+}
+
+// homeSelectors holds the compiled HomeLayout, absent fields being nil
+type homeSelectors struct {
+	fullName, formation, class, centre, schoolYear *cssSelector
+}
+
+// gradeFieldSelectors holds the compiled GradeFields
+type gradeFieldSelectors struct {
+	value, coefficient, title, gradeType, remarks, observation *cssSelector
+}
+
+// LoginLayout describes the SC-Connect login page
+type LoginLayout struct {
+	Email          string   `json:"email"`          // Username input
+	Password       string   `json:"password"`       // Password input
+	Submit         string   `json:"submit"`         // Login button
+	Errors         []string `json:"errors"`         // Where a rejected login is explained
+	PasswordChange string   `json:"passwordChange"` // Inputs of the form shown when the password expired
+	HomePage       string   `json:"homePage"`       // Part of the URL SC-Connect opens once logged in
+
+	Challenges []ChallengeLayout `json:"challenges"` // Interstitials the user is asked to get past, checked in order
+}
+
+// ChallengeLayout describes an interstitial SC-Connect may show after the login form is submitted
+type ChallengeLayout struct {
+	Kind   string `json:"kind"`   // One of the Challenge* kinds
+	Detect string `json:"detect"` // Element whose visibility tells the challenge is shown
+	Input  string `json:"input"`  // Where the answer is typed, empty when the challenge only needs confirming
+	Submit string `json:"submit"` // Button sending the answer
+}
+
+// HomeLayout holds the selectors of the student details shown on the home page, all optional.
+// The details are not read when none is set.
+type HomeLayout struct {
+	FullName   string `json:"fullName"`
+	Formation  string `json:"formation"`
+	Class      string `json:"class"`
+	Centre     string `json:"centre"`
+	SchoolYear string `json:"schoolYear"`
+}
+
+// GradesLayout describes the grades page
+type GradesLayout struct {
+	Page              string      `json:"page"`              // Path of the grades page, relative to the home page
+	NavigationLinks   []string    `json:"navigationLinks"`   // Links to the grades page, when it cannot be opened directly
+	DisplayMode       []string    `json:"displayMode"`       // Radio buttons showing every grade, tried in order
+	CourseTable       string      `json:"courseTable"`       // One table per course
+	CourseName        string      `json:"courseName"`        // Course name, within its table
+	GradeBlock        string      `json:"gradeBlock"`        // One block per grade, within a course table
+	Fields            GradeFields `json:"fields"`            // Grade details, within a grade block
+	CoefficientPrefix string      `json:"coefficientPrefix"` // Text before the coefficient
+	RemarksPrefix     string      `json:"remarksPrefix"`     // Text before the remarks
+	ObservationPrefix string      `json:"observationPrefix"` // Text before the observation
+	TitleDatePattern  string      `json:"titleDatePattern"`  // Splits a title into its title and dd/mm/yyyy date groups
+
+	PeriodSelect       string `json:"periodSelect"`       // Drop-down listing the grading periods, optional
+	PeriodDatesPattern string `json:"periodDatesPattern"` // Finds the dd/mm/yyyy start and end date groups in a period name, optional
+
+	// Match the value of a grade that is not a mark, optional. An empty value is pending,
+	// and a value matching none of them is graded if it is a number, not graded otherwise.
+	AbsentPattern    string `json:"absentPattern"`
+	ExemptPattern    string `json:"exemptPattern"`
+	NotGradedPattern string `json:"notGradedPattern"`
+	PendingPattern   string `json:"pendingPattern"`
+}
+
+// GradeFields holds the selectors of the grade details within a grade block
+type GradeFields struct {
+	Value       string `json:"value"`
+	Coefficient string `json:"coefficient"`
+	Title       string `json:"title"`
+	Type        string `json:"type"`
+	Remarks     string `json:"remarks"`
+	Observation string `json:"observation"`
+}
+
+// AbsencesLayout describes the absences page, which is skipped when Page is empty.
+type AbsencesLayout struct {
+	Page             string        `json:"page"`             // Path of the absences page, relative to the home page
+	Row              string        `json:"row"`              // One row per absence or lateness
+	Fields           AbsenceFields `json:"fields"`           // Absence details, within a row
+	JustifiedPattern string        `json:"justifiedPattern"` // Matches the justified field of a justified absence
+	LatePattern      string        `json:"latePattern"`      // Matches the type field of a lateness
+}
+
+// AbsenceFields holds the selectors of the absence details within a row, only the date is required
+type AbsenceFields struct {
+	Date      string `json:"date"`
+	Slot      string `json:"slot"`
+	Course    string `json:"course"`
+	Justified string `json:"justified"`
+	Reason    string `json:"reason"`
+	Type      string `json:"type"`
+}
+
+// TimetableLayout describes the timetable page, which is skipped when Page is empty.
+type TimetableLayout struct {
+	Page     string          `json:"page"`     // Path of the timetable page, relative to the home page
+	Row      string          `json:"row"`      // One row per session
+	Fields   TimetableFields `json:"fields"`   // Session details, within a row
+	TimeZone string          `json:"timeZone"` // Zone of the dates and times shown, like Europe/Paris
+}
+
+// TimetableFields holds the selectors of the session details within a row, the date and time are required.
+// The time field holds the start and end times, like "08h30 - 12h00".
+type TimetableFields struct {
+	Date    string `json:"date"`
+	Time    string `json:"time"`
+	Course  string `json:"course"`
+	Room    string `json:"room"`
+	Teacher string `json:"teacher"`
+}
+
+// DocumentsLayout describes the page of the official documents, which is skipped when Page is empty
+type DocumentsLayout struct {
+	Page string `json:"page"` // Path of the documents page, relative to the home page
+	Link string `json:"link"` // Links downloading the documents, their text naming them
+}
+
+// timetableSelectors holds the compiled TimetableLayout, absent fields being nil
+type timetableSelectors struct {
+	row                               *cssSelector
+	date, time, course, room, teacher *cssSelector
+	location                          *time.Location
+}
+
+// layoutFile is the content of a layout profile file
+type layoutFile struct {
+	Version  int               `json:"version"`
+	Profiles []json.RawMessage `json:"profiles"`
+}
+
+var (
+	// layouts holds the profiles in use, swapped as a whole on reload
+	layouts atomic.Pointer[[]*LayoutProfile]
+
+	// builtinLayouts holds the profiles of the embedded default file
+	builtinLayouts = sync.OnceValue(func() []*LayoutProfile {
+		profiles, err := parseLayoutFile(defaultLayoutFile, nil)
+		if err != nil {
+			panic(fmt.Sprintf("invalid built-in layout profile: %v", err))
+		}
+		return profiles
+	})
+
+	layoutWatchOnce sync.Once
+)
+
+// Layouts returns the layout profiles in the order they are tried
+func Layouts() []*LayoutProfile {
+	if profiles := layouts.Load(); profiles != nil {
+		return *profiles
+	}
+	return builtinLayouts()
+}
+
+// LoadLayoutsFromEnv loads the profiles of SCFORM_LAYOUT_PATH, if set, and reloads them whenever
+// the file changes, every SCFORM_LAYOUT_RELOAD_INTERVAL. The built-in profile is used otherwise.
+func LoadLayoutsFromEnv() error {
+	path := os.Getenv("SCFORM_LAYOUT_PATH")
+	if path == "" {
+		return nil
+	}
+
+	if err := loadLayouts(path); err != nil {
+		return err
+	}
+
+	if interval := envDuration("SCFORM_LAYOUT_RELOAD_INTERVAL", 5*time.Second); interval > 0 {
+		layoutWatchOnce.Do(func() {
+			go watchLayouts(path, interval)
+		})
+	}
+	return nil
+}
+
+// loadLayouts reads, validates and installs the profiles of a layout file
+func loadLayouts(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read layout profiles: %v", err)
+	}
+
+	profiles, err := parseLayoutFile(data, builtinLayouts()[0])
+	if err != nil {
+		return fmt.Errorf("invalid layout profiles %s: %v", path, err)
+	}
+
+	layouts.Store(&profiles)
+
+	names := make([]string, len(profiles))
+	for i, profile := range profiles {
+		names[i] = profile.Name
+	}
+	log.Printf("Loaded layout profiles from %s: %v", path, names)
+	return nil
+}
+
+// watchLayouts reloads the layout file when its modification time or size changes.
+// An invalid file is reported and the profiles already loaded are kept.
+func watchLayouts(path string, interval time.Duration) {
+	var lastMod time.Time
+	var lastSize int64
+	if info, err := os.Stat(path); err == nil {
+		lastMod, lastSize = info.ModTime(), info.Size()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		info, err := os.Stat(path)
+		if err != nil {
+			DebugLog("Failed to stat layout profiles: %v", err)
+			continue
+		}
+		if info.ModTime().Equal(lastMod) && info.Size() == lastSize {
+			continue
+		}
+		lastMod, lastSize = info.ModTime(), info.Size()
+
+		if err := loadLayouts(path); err != nil {
+			log.Printf("Keeping the current layout profiles: %v", err)
+		}
+	}
+}
+
+// parseLayoutFile decodes and compiles the profiles of a layout file. Each profile starts
+// as a copy of base, when given, so it only needs the fields that differ from it.
+func parseLayoutFile(data []byte, base *LayoutProfile) ([]*LayoutProfile, error) {
+	var file layoutFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	if file.Version != layoutFileVersion {
+		return nil, fmt.Errorf("unsupported version %d, expected %d", file.Version, layoutFileVersion)
+	}
+	if len(file.Profiles) == 0 {
+		return nil, fmt.Errorf("no profile defined")
+	}
+
+	profiles := make([]*LayoutProfile, 0, len(file.Profiles))
+	for i, raw := range file.Profiles {
+		profile := &LayoutProfile{}
+		if base != nil {
+			profile.Login = base.Login
+			profile.Home = base.Home
+			profile.Grades = base.Grades
+			profile.Absences = base.Absences
+			profile.Timetable = base.Timetable
+			profile.Documents = base.Documents
+			// Decoding into a slice reuses its array, which must stay the base one's
+			profile.Login.Errors = slices.Clone(base.Login.Errors)
+			profile.Login.Challenges = slices.Clone(base.Login.Challenges)
+			profile.Grades.NavigationLinks = slices.Clone(base.Grades.NavigationLinks)
+			profile.Grades.DisplayMode = slices.Clone(base.Grades.DisplayMode)
+		}
+		if err := json.Unmarshal(raw, profile); err != nil {
+			return nil, fmt.Errorf("profile %d: %v", i+1, err)
+		}
+		if err := profile.compile(); err != nil {
+			return nil, fmt.Errorf("profile %q: %v", profile.Name, err)
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+// compile validates the profile and compiles what is matched against parsed HTML.
+// Selectors only used in the browser, like the navigation links, are left to it.
+func (p *LayoutProfile) compile() error {
+	if p.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	if p.Login.Submit == "" || p.Login.HomePage == "" || p.Grades.Page == "" {
+		return fmt.Errorf("login submit, home page and grades page are required")
+	}
+
+	// Challenges are only looked for in the browser
+	for i, challenge := range p.Login.Challenges {
+		if challenge.Kind == "" || challenge.Detect == "" || challenge.Submit == "" {
+			return fmt.Errorf("login challenge %d: kind, detect and submit are required", i+1)
+		}
+	}
+
+	var err error
+	compile := func(field, selector string) *cssSelector {
+		if err != nil {
+			return nil
+		}
+		if selector == "" {
+			err = fmt.Errorf("missing %s selector", field)
+			return nil
+		}
+		var sel *cssSelector
+		if sel, err = compileSelector(selector); err != nil {
+			err = fmt.Errorf("%s: %v", field, err)
+		}
+		return sel
+	}
+
+	// Selectors only handed to the browser are compiled too, so a typo is caught on load
+	compile("login email", p.Login.Email)
+	for _, selector := range p.Login.Errors {
+		compile("login error", selector)
+	}
+	for _, selector := range p.Grades.DisplayMode {
+		compile("display mode", selector)
+	}
+	p.loginPassword = compile("login password", p.Login.Password)
+	p.courseTable = compile("course table", p.Grades.CourseTable)
+	p.courseName = compile("course name", p.Grades.CourseName)
+	p.gradeBlock = compile("grade block", p.Grades.GradeBlock)
+	p.fields = gradeFieldSelectors{
+		value:       compile("value", p.Grades.Fields.Value),
+		coefficient: compile("coefficient", p.Grades.Fields.Coefficient),
+		title:       compile("title", p.Grades.Fields.Title),
+		gradeType:   compile("type", p.Grades.Fields.Type),
+		remarks:     compile("remarks", p.Grades.Fields.Remarks),
+		observation: compile("observation", p.Grades.Fields.Observation),
+	}
+	if err != nil {
+		return err
+	}
+
+	if p.titleDate, err = regexp.Compile(p.Grades.TitleDatePattern); err != nil {
+		return fmt.Errorf("title date pattern: %v", err)
+	}
+	if p.titleDate.NumSubexp() != 2 {
+		return fmt.Errorf("title date pattern must have a title and a date group")
+	}
+
+	p.statusText = make(map[GradeStatus]*regexp.Regexp)
+	for status, expr := range map[GradeStatus]string{
+		GradeAbsent:    p.Grades.AbsentPattern,
+		GradeExempt:    p.Grades.ExemptPattern,
+		GradeNotGraded: p.Grades.NotGradedPattern,
+		GradePending:   p.Grades.PendingPattern,
+	} {
+		if expr == "" {
+			continue
+		}
+		if p.statusText[status], err = regexp.Compile(expr); err != nil {
+			return fmt.Errorf("%s pattern: %v", status, err)
+		}
+	}
+
+	// Grading periods are optional, the page is then read as a single view
+	p.periods, p.periodDates = nil, nil
+	if p.Grades.PeriodSelect != "" {
+		if p.periods, err = compileSelector(p.Grades.PeriodSelect); err != nil {
+			return fmt.Errorf("period select: %v", err)
+		}
+	}
+	if p.Grades.PeriodDatesPattern != "" {
+		if p.periodDates, err = regexp.Compile(p.Grades.PeriodDatesPattern); err != nil {
+			return fmt.Errorf("period dates pattern: %v", err)
+		}
+		if p.periodDates.NumSubexp() != 2 {
+			return fmt.Errorf("period dates pattern must have a start and an end date group")
+		}
+	}
+
+	p.home = nil
+	if p.Home != (HomeLayout{}) {
+		if p.home, err = p.Home.compile(); err != nil {
+			return fmt.Errorf("home: %v", err)
+		}
+	}
+
+	p.absences = nil
+	if p.Absences.Page != "" {
+		if p.absences, err = p.Absences.compile(); err != nil {
+			return fmt.Errorf("absences: %v", err)
+		}
+	}
+
+	p.timetable = nil
+	if p.Timetable.Page != "" {
+		if p.timetable, err = p.Timetable.compile(); err != nil {
+			return fmt.Errorf("timetable: %v", err)
+		}
+	}
+
+	p.documents = nil
+	if p.Documents.Page != "" {
+		if p.Documents.Link == "" {
+			return fmt.Errorf("documents: missing link selector")
+		}
+		if p.documents, err = compileSelector(p.Documents.Link); err != nil {
+			return fmt.Errorf("documents: %v", err)
+		}
+	}
+	return nil
+}
+
+// compile compiles the selectors of the home page
+func (h HomeLayout) compile() (*homeSelectors, error) {
+	var err error
+	optional := func(selector string) *cssSelector {
+		if err != nil || selector == "" {
+			return nil
+		}
+		var sel *cssSelector
+		sel, err = compileSelector(selector)
+		return sel
+	}
+
+	compiled := &homeSelectors{
+		fullName:   optional(h.FullName),
+		formation:  optional(h.Formation),
+		class:      optional(h.Class),
+		centre:     optional(h.Centre),
+		schoolYear: optional(h.SchoolYear),
+	}
+	if err != nil {
+		return nil, err
+	}
+	return compiled, nil
+}
+
+// compile compiles the selectors of the timetable page and loads its time zone
+func (t TimetableLayout) compile() (*timetableSelectors, error) {
+	var err error
+	optional := func(selector string) *cssSelector {
+		if err != nil || selector == "" {
+			return nil
+		}
+		var sel *cssSelector
+		sel, err = compileSelector(selector)
+		return sel
+	}
+
+	if t.Row == "" || t.Fields.Date == "" || t.Fields.Time == "" {
+		return nil, fmt.Errorf("row, date and time selectors are required")
+	}
+	compiled := &timetableSelectors{
+		row:      optional(t.Row),
+		date:     optional(t.Fields.Date),
+		time:     optional(t.Fields.Time),
+		course:   optional(t.Fields.Course),
+		room:     optional(t.Fields.Room),
+		teacher:  optional(t.Fields.Teacher),
+		location: time.Local,
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if t.TimeZone != "" {
+		if compiled.location, err = time.LoadLocation(t.TimeZone); err != nil {
+			return nil, fmt.Errorf("time zone: %v", err)
+		}
+	}
+	return compiled, nil
+}
+
+// compile compiles the selectors and patterns of the absences page
+func (a AbsencesLayout) compile() (*absenceSelectors, error) {
+	var err error
+	optional := func(selector string) *cssSelector {
+		if err != nil || selector == "" {
+			return nil
+		}
+		var sel *cssSelector
+		sel, err = compileSelector(selector)
+		return sel
+	}
+	pattern := func(expr string) *regexp.Regexp {
+		if err != nil || expr == "" {
+			return nil
+		}
+		var re *regexp.Regexp
+		re, err = regexp.Compile(expr)
+		return re
+	}
+
+	if a.Row == "" || a.Fields.Date == "" {
+		return nil, fmt.Errorf("row and date selectors are required")
+	}
+	compiled := &absenceSelectors{
+		row:           optional(a.Row),
+		date:          optional(a.Fields.Date),
+		slot:          optional(a.Fields.Slot),
+		course:        optional(a.Fields.Course),
+		justified:     optional(a.Fields.Justified),
+		reason:        optional(a.Fields.Reason),
+		kind:          optional(a.Fields.Type),
+		justifiedText: pattern(a.JustifiedPattern),
+		lateText:      pattern(a.LatePattern),
+	}
+	if err != nil {
+		return nil, err
+	}
+	return compiled, nil
+}
+
+// preferLayout returns the profiles with the given one moved first
+func preferLayout(profiles []*LayoutProfile, first *LayoutProfile) []*LayoutProfile {
+	ordered := []*LayoutProfile{first}
+	for _, profile := range profiles {
+		if profile != first {
+			ordered = append(ordered, profile)
+		}
+	}
+	return ordered
+}
+
+// warnEmptyPage logs that a page of the profile yielded nothing. The built-in selectors of these
+// pages are experimental, and an empty page is the first sign they do not match. It may also just be empty.
+func warnEmptyPage(profile *LayoutProfile, page, what string) {
+	log.Printf("Warning: layout profile %s found no %s on %s, check its selectors against the page if it lists some", profile.Name, what, page)
+}
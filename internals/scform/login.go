@@ -0,0 +1,255 @@
+package scform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// Causes of a rejected login, wrapped in an ErrInvalidCredentials StepError
+var (
+	ErrPasswordExpired = errors.New("password expired")
+	ErrAccountLocked   = errors.New("account locked")
+)
+
+const (
+	// loginOutcomeTimeout bounds how long we wait for SC-Connect to accept or reject the login
+	loginOutcomeTimeout = 10 * time.Second
+	// loginPollInterval is how often the login outcome is checked
+	loginPollInterval = 250 * time.Millisecond
+)
+
+// loginMessagePatterns classify a login error message, checked in order. Password expiry is only
+// recognised from whole phrases, as words like "expire" also show up in session and code messages.
+var loginMessagePatterns = []struct {
+	cause    error
+	keywords []string
+}{
+	{ErrAccountLocked, []string{"verrouill", "bloqu", "désactiv", "desactiv", "suspendu", "locked", "trop de tentatives"}},
+	{ErrPasswordExpired, []string{
+		"mot de passe a expiré", "mot de passe est expiré", "mot de passe expiré",
+		"renouveler votre mot de passe", "changer votre mot de passe", "modifier votre mot de passe",
+		"password has expired", "password expired",
+	}},
+}
+
+// loginOutcomeJS returns the visible error messages, whether a password change form is shown and
+// the index of the first challenge shown, -1 if none
+const loginOutcomeJS = `(selectors, passwordChangeSelector, passwordSelector, challengeSelectors) => {
+	const visible = (el) => !!(el.offsetWidth || el.offsetHeight || el.getClientRects().length);
+	const messages = [];
+	for (const selector of selectors || []) {
+		for (const el of document.querySelectorAll(selector)) {
+			const text = (el.innerText || '').trim();
+			if (text && visible(el) && !messages.includes(text)) {
+				messages.push(text);
+			}
+		}
+	}
+	const passwordChange = !!passwordChangeSelector && !!document.querySelector(passwordChangeSelector);
+	const loginForm = !!document.querySelector(passwordSelector);
+	const challenge = (challengeSelectors || []).findIndex((selector) =>
+		Array.from(document.querySelectorAll(selector)).some(visible));
+	return { messages: messages, passwordChange: passwordChange, loginForm: loginForm, challenge: challenge };
+}`
+
+// loginOutcome is what loginOutcomeJS reports about the login page
+type loginOutcome struct {
+	Messages       []string `json:"messages"`
+	PasswordChange bool     `json:"passwordChange"`
+	LoginForm      bool     `json:"loginForm"`
+	Challenge      int      `json:"challenge"`
+}
+
+// waitLoginOutcome watches the browser after the login form was submitted. It returns the
+// home page tab (Stagiaire.aspx) as soon as it shows up, or an error as soon as SC-Connect rejects the login.
+// The challenges SC-Connect shows on the way are relayed to solve, after calling challenge.
+func waitLoginOutcome(ctx context.Context, browser *rod.Browser, loginPage *rod.Page, profile *LayoutProfile, solve ChallengeSolver, challenge func()) (*rod.Page, error) {
+	deadline := time.Now().Add(loginOutcomeTimeout)
+	var last loginOutcome
+	challenges := make([]string, 0, len(profile.Login.Challenges))
+	for _, challenge := range profile.Login.Challenges {
+		challenges = append(challenges, challenge.Detect)
+	}
+	solved := 0
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// Success: SC-Connect opened the home page, possibly in another tab
+		if tabs, err := contextPages(browser); err == nil {
+			for _, tab := range tabs {
+				if info, err := tab.Info(); err == nil && strings.Contains(info.URL, profile.Login.HomePage) {
+					DebugLog("Login accepted, found tab: %s", info.URL)
+					return tab, nil
+				}
+			}
+		}
+
+		// Failure: an error banner or a password change form is shown on the login page
+		if res, err := loginPage.Timeout(2*time.Second).Eval(loginOutcomeJS, profile.Login.Errors, profile.Login.PasswordChange, profile.Login.Password, challenges); err == nil {
+			last = loginOutcome{Challenge: -1}
+			if err := res.Value.Unmarshal(&last); err != nil {
+				DebugLog("Failed to decode login outcome: %v", err)
+			}
+			if last.PasswordChange {
+				return nil, &StepError{Step: StepLogin, Kind: ErrInvalidCredentials, Err: ErrPasswordExpired}
+			}
+			if len(last.Messages) > 0 {
+				return nil, classifyLoginMessage(strings.Join(last.Messages, "\n"))
+			}
+
+			// Pause on a challenge until the user answers it, then watch the outcome of the answer
+			if last.Challenge >= 0 && last.Challenge < len(profile.Login.Challenges) {
+				if solved == maxChallenges {
+					return nil, &StepError{Step: StepLogin, Kind: ErrInvalidCredentials, Err: fmt.Errorf("%w: still shown after %d answers", ErrChallengeUnanswered, maxChallenges)}
+				}
+				challenge()
+				if err := solveChallenge(ctx, loginPage, profile.Login.Challenges[last.Challenge], solve); err != nil {
+					return nil, err
+				}
+				solved++
+				deadline = time.Now().Add(loginOutcomeTimeout)
+				continue
+			}
+		} else if !errors.Is(err, context.DeadlineExceeded) {
+			// The login page is navigating away, keep watching the tabs
+			DebugLog("Failed to inspect login page: %v", err)
+		}
+
+		if time.Now().After(deadline) {
+			break
+		}
+
+		select {
+		case <-time.After(loginPollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// Neither accepted nor rejected in time: a login form still displayed means it was not accepted
+	if last.LoginForm {
+		return nil, stepErrorf(StepLogin, ErrInvalidCredentials, "login form still displayed after %s", loginOutcomeTimeout)
+	}
+
+	DebugLog("No login outcome detected, carrying on with the current page")
+	return loginPage, nil
+}
+
+// contextPages returns the pages of the browser context. On an incognito context of a pooled
+// browser, the other contexts are left out so a retrieval never picks up another user's tab.
+func contextPages(browser *rod.Browser) (rod.Pages, error) {
+	list, err := proto.TargetGetTargets{}.Call(browser)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := rod.Pages{}
+	for _, target := range list.TargetInfos {
+		if target.Type != proto.TargetTargetInfoTypePage {
+			continue
+		}
+		// A browser of its own (no pool, recording, replay) has no context ID and owns every tab
+		if browser.BrowserContextID != "" && target.BrowserContextID != browser.BrowserContextID {
+			continue
+		}
+		page, err := browser.PageFromTarget(target.TargetID)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+	return pages, nil
+}
+
+// classifyLoginMessage turns an SC-Connect login error message into a StepError
+func classifyLoginMessage(message string) error {
+	lower := strings.ToLower(message)
+	for _, pattern := range loginMessagePatterns {
+		for _, keyword := range pattern.keywords {
+			if strings.Contains(lower, keyword) {
+				return &StepError{Step: StepLogin, Kind: ErrInvalidCredentials, Err: fmt.Errorf("%w: %s", pattern.cause, message)}
+			}
+		}
+	}
+	return stepErrorf(StepLogin, ErrInvalidCredentials, "%s", message)
+}
+
+// login fills and submits the SC-Connect login form in page, returning the page of the home tab
+// SC-Connect opens and the layout profile the login page matched. watch is called with that tab
+// when it is not page, before it navigates, and challenge before a login challenge is relayed.
+func login(ctx context.Context, browser *rod.Browser, page *rod.Page, creds Credentials, profiles []*LayoutProfile, progress ProgressSink, step func(string), watch func(*rod.Page) error, challenge func()) (*rod.Page, *LayoutProfile, error) {
+	// Set a shorter timeout for page navigation (15 seconds)
+	if err := page.Timeout(15 * time.Second).Navigate(creds.URL); err != nil {
+		return nil, nil, stepError(StepOpenLogin, ErrUpstreamDown, err)
+	}
+
+	// Wait for the page to load and Angular to initialize
+	if err := page.Timeout(10 * time.Second).WaitStable(time.Second); err != nil {
+		return nil, nil, stepError(StepOpenLogin, ErrUpstreamDown, err)
+	}
+
+	// Use more targeted wait with a timeout instead of waiting for DOM stable
+	// Wait up to 10 seconds for the email input field of any profile, the first one found tells the layout
+	emailInput, profile, err := raceLayouts(page.Timeout(10*time.Second), profiles, func(p *LayoutProfile) string { return p.Login.Email })
+	if err != nil {
+		return nil, nil, stepError(StepOpenLogin, ErrLayoutChanged, err)
+	}
+	DebugLog("Login page matches layout profile %s", profile.Name)
+	if err := emailInput.Input(creds.Username); err != nil {
+		return nil, nil, stepError(StepLogin, ErrLayoutChanged, err)
+	}
+
+	step(StepLogin)
+
+	// Wait up to 5 seconds for the password input field
+	passwordInput, err := page.Timeout(5 * time.Second).Element(profile.Login.Password)
+	if err != nil {
+		return nil, nil, stepError(StepOpenLogin, ErrLayoutChanged, err)
+	}
+	if err := passwordInput.Input(creds.Password); err != nil {
+		return nil, nil, stepError(StepLogin, ErrLayoutChanged, err)
+	}
+
+	// Send progress update
+	progress.Send(ProgressUpdate{
+		Status:  "logging_in",
+		Message: "Logging in...",
+	})
+
+	// Click the login button
+	loginButton, err := page.Timeout(5 * time.Second).Element(profile.Login.Submit)
+	if err != nil {
+		return nil, nil, stepError(StepLogin, ErrLayoutChanged, err)
+	}
+	if err := loginButton.Click(proto.InputMouseButtonLeft, 1); err != nil {
+		return nil, nil, stepError(StepLogin, ErrLayoutChanged, err)
+	}
+
+	// Wait for SC-Connect to accept or reject the login, and switch to the Stagiaire.aspx tab
+	loginPage := page
+	page, err = waitLoginOutcome(ctx, browser, page, profile, creds.Challenges, challenge)
+	if err != nil {
+		return nil, nil, err
+	}
+	if page != loginPage {
+		if err := watch(page); err != nil {
+			return nil, nil, stepError(StepLogin, ErrBrowserUnavailable, err)
+		}
+	}
+
+	// Wait for navigation after login
+	if err := page.Timeout(10 * time.Second).WaitStable(time.Second); err != nil {
+		return nil, nil, stepError(StepLogin, ErrUpstreamDown, err)
+	}
+
+	return page, profile, nil
+}
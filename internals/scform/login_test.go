@@ -0,0 +1,42 @@
+package scform
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClassifyLoginMessage(t *testing.T) {
+	tests := []struct {
+		message string
+		cause   error // nil for a plain invalid credentials error
+	}{
+		{"Identifiant ou mot de passe incorrect.", nil},
+		{"Votre compte est verrouillé suite à trop de tentatives.", ErrAccountLocked},
+		{"Compte bloqué, contactez votre centre.", ErrAccountLocked},
+		{"Votre compte a été désactivé.", ErrAccountLocked},
+		{"Your account is locked.", ErrAccountLocked},
+		{"Votre mot de passe a expiré.", ErrPasswordExpired},
+		{"Mot de passe expiré", ErrPasswordExpired},
+		{"Veuillez changer votre mot de passe.", ErrPasswordExpired},
+		{"Vous devez renouveler votre mot de passe.", ErrPasswordExpired},
+		{"Your password has expired.", ErrPasswordExpired},
+		// Expiry of something other than the password
+		{"Votre session a expiré, veuillez vous reconnecter.", nil},
+		{"Le code de vérification expire dans 5 minutes.", nil},
+		{"Saisissez votre nouveau mot de passe reçu par e-mail.", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			err := classifyLoginMessage(tt.message)
+			if !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("%v is not ErrInvalidCredentials", err)
+			}
+			for _, cause := range []error{ErrAccountLocked, ErrPasswordExpired} {
+				if got, want := errors.Is(err, cause), cause == tt.cause; got != want {
+					t.Errorf("errors.Is(%v, %v) = %v, want %v", err, cause, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package scform
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+	"golang.org/x/net/html"
+)
+
+// LoginStore keeps the SCForm cookies of the users who logged in, so their next retrieval can skip
+// the login. Each file is encrypted with a key derived from the store secret and the credentials,
+// so it can only be read back by someone giving the same password.
+type LoginStore struct {
+	Dir    string
+	MaxAge time.Duration // Age above which a saved login is not tried anymore
+
+	secret []byte
+	mu     sync.Mutex
+}
+
+// savedLogin is what is kept of a successful login
+type savedLogin struct {
+	HomeURL string                      `json:"homeURL"` // Home page the login led to
+	Layout  string                      `json:"layout"`  // Layout profile of the login page
+	Profile Profile                     `json:"profile"` // Details read from the home page
+	Cookies []*proto.NetworkCookieParam `json:"cookies"`
+	Saved   time.Time                   `json:"saved"`
+}
+
+// NewLoginStoreFromEnv creates a store in SCFORM_LOGIN_DIR, defaulting to the system temp directory,
+// encrypted with SCFORM_LOGIN_KEY and keeping logins for SCFORM_LOGIN_MAX_AGE, defaulting to 12 hours.
+// Without a key, a random one is used and saved logins do not survive a restart. It returns nil if
+// the max age is 0.
+func NewLoginStoreFromEnv() *LoginStore {
+	maxAge := 12 * time.Hour
+	if value := os.Getenv("SCFORM_LOGIN_MAX_AGE"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			log.Printf("Invalid SCFORM_LOGIN_MAX_AGE %q, using %s", value, maxAge)
+		} else {
+			maxAge = parsed
+		}
+	}
+	if maxAge == 0 {
+		return nil
+	}
+
+	dir := os.Getenv("SCFORM_LOGIN_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "scform-logins")
+	}
+
+	secret := []byte(os.Getenv("SCFORM_LOGIN_KEY"))
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Printf("Failed to create a login store key, logins will not be saved: %v", err)
+			return nil
+		}
+		DebugLog("SCFORM_LOGIN_KEY is not set, saved logins will not survive a restart")
+	}
+
+	return &LoginStore{Dir: dir, MaxAge: maxAge, secret: secret}
+}
+
+// path returns the file of the login of a user, named after a hash so it does not reveal the username
+func (s *LoginStore) path(creds Credentials) string {
+	sum := sha256.Sum256([]byte(creds.URL + "\x00" + creds.Username))
+	return filepath.Join(s.Dir, hex.EncodeToString(sum[:])+".login")
+}
+
+// cipher returns the cipher of the login of a user
+func (s *LoginStore) cipher(creds Credentials) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(string(s.secret) + "\x00" + creds.URL + "\x00" + creds.Username + "\x00" + creds.Password))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Save encrypts and stores a login, replacing the previous one of the user
+func (s *LoginStore) Save(creds Credentials, login *savedLogin) error {
+	data, err := json.Marshal(login)
+	if err != nil {
+		return err
+	}
+	aead, err := s.cipher(creds)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := aead.Seal(nonce, nonce, data, nil)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path(creds), sealed, 0o600)
+}
+
+// Load returns the saved login of a user, or nil if there is none younger than MaxAge.
+// A login saved with another password cannot be decrypted and is reported as an error.
+func (s *LoginStore) Load(creds Credentials) (*savedLogin, error) {
+	s.mu.Lock()
+	sealed, err := os.ReadFile(s.path(creds))
+	s.mu.Unlock()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := s.cipher(creds)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("saved login is truncated")
+	}
+	data, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt saved login: %v", err)
+	}
+
+	var login savedLogin
+	if err := json.Unmarshal(data, &login); err != nil {
+		return nil, fmt.Errorf("failed to parse saved login: %v", err)
+	}
+	if time.Since(login.Saved) > s.MaxAge {
+		return nil, nil
+	}
+	return &login, nil
+}
+
+// Delete removes the saved login of a user
+func (s *LoginStore) Delete(creds Credentials) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(s.path(creds)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// loadLogin returns the saved login of a user, or nil if there is none or it cannot be read
+func (s *RodSource) loadLogin(creds Credentials) *savedLogin {
+	if s.Logins == nil {
+		return nil
+	}
+	login, err := s.Logins.Load(creds)
+	if err != nil {
+		DebugLog("Ignoring the saved login of %s: %v", creds.Username, err)
+		return nil
+	}
+	return login
+}
+
+// saveLogin stores the cookies of the browser after a successful login
+func (s *RodSource) saveLogin(browser *rod.Browser, creds Credentials, login *savedLogin) {
+	if s.Logins == nil {
+		return
+	}
+
+	cookies, err := browser.GetCookies()
+	if err != nil {
+		log.Printf("Failed to export the cookies of %s: %v", creds.Username, err)
+		return
+	}
+	login.Cookies = proto.CookiesToParams(cookies)
+	login.Saved = time.Now()
+
+	if err := s.Logins.Save(creds, login); err != nil {
+		log.Printf("Failed to save the login of %s: %v", creds.Username, err)
+	}
+}
+
+// forgetLogin removes the saved login of a user, once SCForm no longer accepts it
+func (s *RodSource) forgetLogin(creds Credentials) {
+	if s.Logins == nil {
+		return
+	}
+	if err := s.Logins.Delete(creds); err != nil {
+		log.Printf("Failed to delete the saved login of %s: %v", creds.Username, err)
+	}
+}
+
+// resumeLogin restores the cookies of a saved login and opens the grades page with them, returning
+// the layout profile of the login. It reports false, the cookies being cleared, if SCForm does not
+// accept them anymore and shows its login form instead.
+func resumeLogin(browser *rod.Browser, page *rod.Page, login *savedLogin, profiles []*LayoutProfile) (*LayoutProfile, bool) {
+	var profile *LayoutProfile
+	for _, candidate := range profiles {
+		if candidate.Name == login.Layout {
+			profile = candidate
+			break
+		}
+	}
+	home, err := url.Parse(login.HomeURL)
+	if profile == nil || err != nil {
+		return nil, false
+	}
+	gradesURL := home.ResolveReference(&url.URL{Path: profile.Grades.Page}).String()
+
+	resumed := func() bool {
+		if err := browser.SetCookies(login.Cookies); err != nil {
+			DebugLog("Failed to restore cookies: %v", err)
+			return false
+		}
+		if err := page.Timeout(15 * time.Second).Navigate(gradesURL); err != nil {
+			DebugLog("Failed to open the grades page with the saved login: %v", err)
+			return false
+		}
+		if err := page.Timeout(10 * time.Second).WaitStable(time.Second); err != nil {
+			DebugLog("Grades page opened with the saved login did not settle: %v", err)
+			return false
+		}
+
+		// The course tables may only show once the display mode is switched, which readGrades
+		// does, so an expired session is told by the login form it is sent back to instead
+		pageHTML, err := page.HTML()
+		if err != nil {
+			DebugLog("Failed to read the grades page opened with the saved login: %v", err)
+			return false
+		}
+		doc, err := html.Parse(strings.NewReader(pageHTML))
+		if err != nil {
+			DebugLog("Failed to parse the grades page opened with the saved login: %v", err)
+			return false
+		}
+		if loginFormShown(doc, profile) {
+			DebugLog("Login form shown instead of the grades page opened with the saved login")
+			return false
+		}
+		return true
+	}()
+	if !resumed {
+		if err := browser.SetCookies(nil); err != nil {
+			DebugLog("Failed to clear restored cookies: %v", err)
+		}
+		return nil, false
+	}
+	return profile, true
+}
+
+// loginFormShown reports whether the page is the login form, where SCForm sends an expired session
+// whatever page it asked for
+func loginFormShown(doc *html.Node, profile *LayoutProfile) bool {
+	return profile.loginPassword != nil && findFirst(doc, profile.loginPassword.match) != nil
+}
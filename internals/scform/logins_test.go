@@ -0,0 +1,135 @@
+package scform
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+func TestLoginStore(t *testing.T) {
+	creds := Credentials{URL: "https://sc.example/login", Username: "jane.doe@example.com", Password: "secret"}
+	login := &savedLogin{
+		HomeURL: "https://sc.example/Stagiaire.aspx",
+		Layout:  "default",
+		Profile: Profile{FullName: "Jane Doe", Class: "BTS 1"},
+		Cookies: []*proto.NetworkCookieParam{{Name: "ASP.NET_SessionId", Value: "abc123", Domain: "sc.example", Path: "/"}},
+	}
+
+	tests := []struct {
+		name   string
+		saved  time.Duration // Age of the login when loaded
+		load   Credentials
+		want   bool
+		errors bool
+	}{
+		{"same credentials", 0, creds, true, false},
+		{"other password", 0, Credentials{URL: creds.URL, Username: creds.Username, Password: "other"}, false, true},
+		{"other user", 0, Credentials{URL: creds.URL, Username: "john.doe@example.com", Password: creds.Password}, false, false},
+		{"other site", 0, Credentials{URL: "https://other.example/login", Username: creds.Username, Password: creds.Password}, false, false},
+		{"expired", 2 * time.Hour, creds, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &LoginStore{Dir: filepath.Join(t.TempDir(), "logins"), MaxAge: time.Hour, secret: []byte("key")}
+
+			saving := *login
+			saving.Saved = time.Now().Add(-tt.saved)
+			if err := store.Save(creds, &saving); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := store.Load(tt.load)
+			if (err != nil) != tt.errors {
+				t.Fatalf("Load error %v, want error %v", err, tt.errors)
+			}
+			if (got != nil) != tt.want {
+				t.Fatalf("Load = %+v, want a login %v", got, tt.want)
+			}
+			if got == nil {
+				return
+			}
+			if got.HomeURL != login.HomeURL || got.Layout != login.Layout || got.Profile != login.Profile {
+				t.Errorf("Load = %+v, want %+v", got, login)
+			}
+			if len(got.Cookies) != 1 || *got.Cookies[0] != *login.Cookies[0] {
+				t.Errorf("cookies %+v, want %+v", got.Cookies, login.Cookies)
+			}
+		})
+	}
+}
+
+func TestLoginStoreFiles(t *testing.T) {
+	creds := Credentials{URL: "https://sc.example/login", Username: "jane.doe@example.com", Password: "secret"}
+	store := &LoginStore{Dir: filepath.Join(t.TempDir(), "logins"), MaxAge: time.Hour, secret: []byte("key")}
+
+	if err := store.Save(creds, &savedLogin{HomeURL: "https://sc.example/Stagiaire.aspx", Saved: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files are private and reveal neither the user nor the login
+	data, err := os.ReadFile(store.path(creds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("Stagiaire")) {
+		t.Error("saved login is not encrypted")
+	}
+	if strings.Contains(store.path(creds), "jane") {
+		t.Errorf("file name %s reveals the username", store.path(creds))
+	}
+	if info, err := os.Stat(store.path(creds)); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("file mode %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+
+	// Another store key cannot read it
+	other := &LoginStore{Dir: store.Dir, MaxAge: time.Hour, secret: []byte("other key")}
+	if _, err := other.Load(creds); err == nil {
+		t.Error("login read with another store key")
+	}
+
+	// A truncated file is an error, not a login
+	if err := os.WriteFile(store.path(creds), data[:4], 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load(creds); err == nil {
+		t.Error("truncated login loaded")
+	}
+
+	if err := store.Delete(creds); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.Load(creds); got != nil || err != nil {
+		t.Errorf("Load after Delete = %+v, %v", got, err)
+	}
+	if err := store.Delete(creds); err != nil {
+		t.Errorf("Delete without a login: %v", err)
+	}
+}
+
+func TestLoginFormShown(t *testing.T) {
+	profile := builtinLayouts()[0]
+
+	// A saved login is resumed as long as the grades page opened with it is not the login form,
+	// whether its course tables are shown yet or not
+	tests := []struct {
+		name string
+		doc  string // Fixture file, or markup when it starts with <
+		want bool
+	}{
+		{"grades page", "grades.html", false},
+		{"grades page before the display mode is switched", `<form id="form1"><input type="radio" id="MainContent_RadioButtonAffichage_1"></form>`, false},
+		{"login form", `<form><input id="email"><input id="password" type="password"><button type="submit">Connexion</button></form>`, true},
+	}
+
+	for _, tt := range tests {
+		if got := loginFormShown(parseTestDoc(t, tt.doc), profile); got != tt.want {
+			t.Errorf("%s: loginFormShown = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package scform
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// defaultOutOf is the maximum of a grade shown without one
+const defaultOutOf = 20
+
+// markRegex matches the value of a grade that is a mark
+var markRegex = regexp.MustCompile(`^-?\d+(?:[.,]\d+)?`)
+
+// outOfRegex splits a grade shown with its maximum, like "7,5/10" or "85 sur 100"
+var outOfRegex = regexp.MustCompile(`^(-?\d+(?:[.,]\d+)?)\s*(?:/|sur)\s*(\d+(?:[.,]\d+)?)`)
+
+// rawGrade holds the text of the fields of a single grade block, as shown on the page
+type rawGrade struct {
+	Value       string
+	Coefficient string
+	Title       string
+	Type        string
+	Remarks     string
+	Observation string
+}
+
+// rawCourse holds the text extracted from a single course table
+type rawCourse struct {
+	Name   string
+	Grades []rawGrade
+}
+
+// periodOption is a grading period listed in the period drop-down of the grades page
+type periodOption struct {
+	Name     string
+	Value    string
+	Selected bool
+}
+
+// ParseGradesHTML parses a saved MesNotes.aspx page and builds the student grades,
+// trying the layout profiles in order. The returned student has no name, callers are expected to fill it.
+func ParseGradesHTML(r io.Reader) (*Student, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse grades page: %v", err)
+	}
+
+	courses, _ := parseCourses(doc, Layouts())
+	student := &Student{
+		Grades: courses,
+	}
+	student.CalculateTotalAverage()
+
+	return student, nil
+}
+
+// parseCourses builds the courses with the first profile whose course tables are found in the
+// document, returning that profile too. It returns no course and a nil profile if none matches.
+func parseCourses(doc *html.Node, profiles []*LayoutProfile) ([]Course, *LayoutProfile) {
+	for _, profile := range profiles {
+		if raw := extractRawCourses(doc, profile); len(raw) > 0 {
+			DebugLog("Parsing grades with layout profile %s", profile.Name)
+			return buildCourses(raw, profile), profile
+		}
+	}
+	return nil, nil
+}
+
+// findPeriods returns the period drop-down of the grades page and its options.
+// It returns nil and no option if the profile or the page lists no period.
+func findPeriods(doc *html.Node, profile *LayoutProfile) (*html.Node, []periodOption) {
+	if profile.periods == nil {
+		return nil, nil
+	}
+	sel := findFirst(doc, profile.periods.match)
+	if sel == nil {
+		return nil, nil
+	}
+
+	var options []periodOption
+	selected := false
+	for _, n := range findAll(sel, func(n *html.Node) bool { return n.Data == "option" }) {
+		option := periodOption{Name: textContent(n), Selected: !selected && hasAttr(n, "selected")}
+		value, ok := attrOK(n, "value")
+		if !ok {
+			value = option.Name
+		}
+		option.Value = value
+		selected = selected || option.Selected
+		options = append(options, option)
+	}
+
+	// Like a browser, the first option is shown when none is selected
+	if !selected && len(options) > 0 {
+		options[0].Selected = true
+	}
+	return sel, options
+}
+
+// newPeriod builds the period of an option from its courses, reading its dates from its name
+func newPeriod(option periodOption, courses []Course, profile *LayoutProfile) Period {
+	period := Period{
+		Name:    strings.TrimSpace(option.Name),
+		Courses: courses,
+	}
+	if period.Courses == nil {
+		period.Courses = []Course{}
+	}
+
+	if profile.periodDates != nil {
+		if matches := profile.periodDates.FindStringSubmatch(period.Name); len(matches) == 3 {
+			period.Start = parseDate(matches[1])
+			period.End = parseDate(matches[2])
+		}
+	}
+	return period
+}
+
+// extractRawCourses walks the document and collects the text of every course table
+func extractRawCourses(doc *html.Node, profile *LayoutProfile) []rawCourse {
+	var courses []rawCourse
+
+	for _, table := range findAll(doc, profile.courseTable.match) {
+		nameNode := findFirst(table, profile.courseName.match)
+		if nameNode == nil {
+			DebugLog("Failed to find course name element, skipping table")
+			continue
+		}
+
+		course := rawCourse{Name: textContent(nameNode)}
+
+		fields := profile.fields
+		for _, block := range findAll(table, profile.gradeBlock.match) {
+			course.Grades = append(course.Grades, rawGrade{
+				Value:       selectText(block, fields.value),
+				Coefficient: selectText(block, fields.coefficient),
+				Title:       selectText(block, fields.title),
+				Type:        selectText(block, fields.gradeType),
+				Remarks:     selectText(block, fields.remarks),
+				Observation: selectText(block, fields.observation),
+			})
+		}
+
+		courses = append(courses, course)
+	}
+
+	return courses
+}
+
+// buildCourses converts the raw page text into courses, dropping empty grades and courses
+func buildCourses(raw []rawCourse, profile *LayoutProfile) []Course {
+	var courses []Course
+
+	for _, rc := range raw {
+		course := Course{
+			Name:   strings.TrimSpace(rc.Name),
+			Grades: []Grade{},
+		}
+
+		for _, rg := range rc.Grades {
+			grade := buildGrade(rg, profile)
+
+			// Only append grade if we have at least some basic information, a 0 being a real mark
+			if grade.Status != GradePending || grade.Title != "" {
+				course.Grades = append(course.Grades, grade)
+			}
+		}
+
+		// Only append course if it has a name and at least one grade
+		if course.Name != "" && len(course.Grades) > 0 {
+			courses = append(courses, course)
+		}
+	}
+
+	return courses
+}
+
+// buildGrade converts the text of a grade block into a Grade
+func buildGrade(rg rawGrade, profile *LayoutProfile) Grade {
+	grade := Grade{}
+
+	value := strings.TrimSpace(rg.Value)
+	grade.Status = parseGradeStatus(value, profile)
+	grade.Value, grade.OutOf = parseGradeValue(value)
+	if grade.Status != GradeGraded {
+		grade.Value = 0
+	}
+
+	coeffText := strings.TrimSpace(rg.Coefficient)
+	coeffText = strings.TrimPrefix(coeffText, profile.Grades.CoefficientPrefix)
+	grade.Coefficient = parseFloat(coeffText)
+
+	titleText := strings.TrimSpace(rg.Title)
+	if matches := profile.titleDate.FindStringSubmatch(titleText); len(matches) == 3 {
+		grade.Title = strings.TrimSpace(matches[1])
+		grade.Date = parseDate(strings.TrimSpace(matches[2]))
+	} else {
+		// If no date pattern found, use the entire text as title
+		grade.Title = titleText
+	}
+
+	grade.Type = strings.TrimSpace(rg.Type)
+	grade.Remarks = strings.TrimPrefix(strings.TrimSpace(rg.Remarks), profile.Grades.RemarksPrefix)
+	grade.Observation = strings.TrimPrefix(strings.TrimSpace(rg.Observation), profile.Grades.ObservationPrefix)
+
+	return grade
+}
+
+// parseGradeStatus tells from the value of a grade whether it is a mark, or why not
+func parseGradeStatus(value string, profile *LayoutProfile) GradeStatus {
+	if value == "" {
+		return GradePending
+	}
+	for _, status := range gradeStatuses {
+		if re := profile.statusText[status]; re != nil && re.MatchString(value) {
+			return status
+		}
+	}
+	if markRegex.MatchString(value) {
+		return GradeGraded
+	}
+	DebugLog("Grade value %q is not a mark", value)
+	return GradeNotGraded
+}
+
+// parseGradeValue reads the value of a grade and its maximum, defaulting to 20 when the text has none
+func parseGradeValue(text string) (float64, float64) {
+	if matches := outOfRegex.FindStringSubmatch(text); matches != nil {
+		if outOf := parseFloat(matches[2]); outOf > 0 {
+			return parseFloat(matches[1]), outOf
+		}
+	}
+	return parseFloat(text), defaultOutOf
+}
+
+// selectText returns the text of the first descendant matching the selector, or "" if none
+func selectText(n *html.Node, sel *cssSelector) string {
+	found := findFirst(n, sel.match)
+	if found == nil {
+		return ""
+	}
+	return textContent(found)
+}
+
+// findFirst returns the first descendant element of n matching the predicate, in document order
+func findFirst(n *html.Node, match func(*html.Node) bool) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && match(c) {
+			return c
+		}
+		if found := findFirst(c, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// findAll returns every descendant element of n matching the predicate, in document order
+func findAll(n *html.Node, match func(*html.Node) bool) []*html.Node {
+	var nodes []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && match(c) {
+			nodes = append(nodes, c)
+		}
+		nodes = append(nodes, findAll(c, match)...)
+	}
+	return nodes
+}
+
+// attr returns the value of the named attribute, or "" if absent
+func attr(n *html.Node, name string) string {
+	value, _ := attrOK(n, name)
+	return value
+}
+
+// hasAttr reports whether the element has the named attribute
+func hasAttr(n *html.Node, name string) bool {
+	_, ok := attrOK(n, name)
+	return ok
+}
+
+// attrOK returns the value of the named attribute and whether it is present
+func attrOK(n *html.Node, name string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// textContent returns the text of a node, turning <br> into line breaks like innerText
+func textContent(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			sb.WriteString(n.Data)
+		case n.Type == html.ElementNode && n.Data == "br":
+			sb.WriteString("\n")
+		case n.Type == html.ElementNode && (n.Data == "script" || n.Data == "style"):
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.TrimSpace(sb.String())
+}
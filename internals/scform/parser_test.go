@@ -0,0 +1,186 @@
+package scform
+
+import (
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+func TestParseGradesHTML(t *testing.T) {
+	f, err := os.Open("testdata/grades.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	student, err := ParseGradesHTML(f)
+	if err != nil {
+		t.Fatalf("ParseGradesHTML: %v", err)
+	}
+
+	want := []Course{
+		{Name: "Mathématiques", Grades: []Grade{
+			{Value: 15.5, OutOf: 20, Coefficient: 2, Title: "Devoir surveillé", Date: date(2024, 10, 12), Type: "Examen", Remarks: "Bon travail", Observation: "RAS", Status: GradeGraded},
+			{Value: 7.5, OutOf: 10, Coefficient: 1, Title: "Interrogation", Date: date(2024, 11, 20), Type: "Contrôle", Status: GradeGraded},
+			{OutOf: 20, Coefficient: 3, Title: "Partiel", Date: date(2025, 1, 15), Status: GradeAbsent},
+		}},
+		{Name: "Anglais", Grades: []Grade{
+			{OutOf: 20, Coefficient: 1, Title: "Oral", Status: GradeGraded},
+			{OutOf: 20, Coefficient: 1, Title: "Écrit", Date: date(2024, 12, 5), Status: GradeExempt},
+		}},
+	}
+
+	if len(student.Grades) != len(want) {
+		t.Fatalf("got %d courses, want %d: %+v", len(student.Grades), len(want), student.Grades)
+	}
+	for i, course := range student.Grades {
+		if course.Name != want[i].Name {
+			t.Errorf("course %d: name %q, want %q", i, course.Name, want[i].Name)
+		}
+		if len(course.Grades) != len(want[i].Grades) {
+			t.Errorf("%s: got %d grades, want %d: %+v", course.Name, len(course.Grades), len(want[i].Grades), course.Grades)
+			continue
+		}
+		for j, grade := range course.Grades {
+			if grade != want[i].Grades[j] {
+				t.Errorf("%s grade %d:\n got %+v\nwant %+v", course.Name, j, grade, want[i].Grades[j])
+			}
+		}
+	}
+
+	// 15.5/20 coeff. 2 and 7.5/10 coeff. 1, the absence being left out
+	if got, want := student.Grades[0].Average, 46.0/3; !approx(got, want) {
+		t.Errorf("Mathématiques average %g, want %g", got, want)
+	}
+	if got, want := student.TotalAverage, 11.5; !approx(got, want) {
+		t.Errorf("total average %g, want %g", got, want)
+	}
+}
+
+func TestParseGradesHTMLWithoutCourses(t *testing.T) {
+	student, err := ParseGradesHTML(strings.NewReader("<html><body><p>Aucune note</p></body></html>"))
+	if err != nil {
+		t.Fatalf("ParseGradesHTML: %v", err)
+	}
+	if len(student.Grades) != 0 || student.TotalAverage != 0 {
+		t.Errorf("got %+v, want no course", student)
+	}
+}
+
+func TestParseGradeValue(t *testing.T) {
+	tests := []struct {
+		text         string
+		value, outOf float64
+	}{
+		{"15.5", 15.5, 20},
+		{"15,5", 15.5, 20},
+		{"7,5/10", 7.5, 10},
+		{"7.5 / 10", 7.5, 10},
+		{"85 sur 100", 85, 100},
+		{"0/20", 0, 20},
+		{"-1", -1, 20},
+		// A zero maximum is no maximum
+		{"12/0", 12, 20},
+		{"Abs", 0, 20},
+		{"", 0, 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			value, outOf := parseGradeValue(tt.text)
+			if !approx(value, tt.value) || !approx(outOf, tt.outOf) {
+				t.Errorf("parseGradeValue(%q) = %g/%g, want %g/%g", tt.text, value, outOf, tt.value, tt.outOf)
+			}
+		})
+	}
+}
+
+func TestParseGradeStatus(t *testing.T) {
+	profile := builtinLayouts()[0]
+
+	tests := []struct {
+		value string
+		want  GradeStatus
+	}{
+		{"14,5", GradeGraded},
+		{"0", GradeGraded},
+		{"7.5/10", GradeGraded},
+		{"", GradePending},
+		{"Abs", GradeAbsent},
+		{"ABS.", GradeAbsent},
+		{"absent", GradeAbsent},
+		{"Disp", GradeExempt},
+		{"Dispensé", GradeExempt},
+		{"NN", GradeNotGraded},
+		{"n.n.", GradeNotGraded},
+		{"Non noté", GradeNotGraded},
+		{"En attente", GradePending},
+		{"à venir", GradePending},
+		{"--", GradePending},
+		{"A+", GradeNotGraded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := parseGradeStatus(tt.value, profile); got != tt.want {
+				t.Errorf("parseGradeStatus(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+// parseFixture parses a saved page of testdata
+func parseFixture(t *testing.T, name string) *html.Node {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	doc, err := html.Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// parseTestDoc parses markup, or the saved page of testdata it names when it does not start with <
+func parseTestDoc(t *testing.T, doc string) *html.Node {
+	t.Helper()
+	if !strings.HasPrefix(doc, "<") {
+		return parseFixture(t, doc)
+	}
+	node, err := html.Parse(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
+// testLayout returns the profile of testdata/layout.json, which turns on the sections the built-in
+// profile leaves off. Its selectors and the pages of testdata are examples, not SCForm captures.
+func testLayout(t *testing.T) *LayoutProfile {
+	t.Helper()
+	data, err := os.ReadFile("testdata/layout.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profiles, err := parseLayoutFile(data, builtinLayouts()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return profiles[0]
+}
@@ -0,0 +1,318 @@
+package scform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// BrowserPool keeps a long-lived browser and hands out isolated incognito contexts from it.
+// At most MaxConcurrency contexts are leased at once, other callers wait in line. The browser
+// is recycled after MaxUses leases, or as soon as it fails a health check or a lease reports a crash.
+type BrowserPool struct {
+	MaxConcurrency      int           // Contexts leased at once
+	MaxUses             int           // Leases served by a browser before it is recycled, 0 for no limit
+	HealthCheckInterval time.Duration // How often the browser is checked, 0 to disable
+
+	connect func(ctx context.Context) (*rod.Browser, error)
+	slots   chan struct{}
+	waiting int
+
+	mu         sync.Mutex
+	current    *pooledBrowser
+	connecting chan struct{} // Closed once the browser being started is ready, nil if none is
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+// pooledBrowser is a browser of the pool with its usage counters
+type pooledBrowser struct {
+	browser *rod.Browser
+	uses    int
+	active  int
+	retired bool
+	err     error // Why the browser was retired, if it failed
+}
+
+// BrowserLease is an incognito browser context leased from a BrowserPool
+type BrowserLease struct {
+	Browser *rod.Browser // Incognito browser, bound to the context given to Acquire
+
+	pool     *BrowserPool
+	pooled   *pooledBrowser
+	released bool
+}
+
+// PoolStats describes the state of a BrowserPool
+type PoolStats struct {
+	Active  int `json:"active"`  // Contexts currently leased
+	Waiting int `json:"waiting"` // Callers waiting for a context
+	Uses    int `json:"uses"`    // Leases served by the current browser
+}
+
+// NewBrowserPool creates a pool using connect to start its browsers
+func NewBrowserPool(maxConcurrency, maxUses int, healthCheckInterval time.Duration, connect func(ctx context.Context) (*rod.Browser, error)) *BrowserPool {
+	if maxConcurrency <= 0 {
+		maxConcurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &BrowserPool{
+		MaxConcurrency:      maxConcurrency,
+		MaxUses:             maxUses,
+		HealthCheckInterval: healthCheckInterval,
+		connect:             connect,
+		slots:               make(chan struct{}, maxConcurrency),
+		ctx:                 ctx,
+		cancel:              cancel,
+	}
+
+	if healthCheckInterval > 0 {
+		go p.healthCheckLoop()
+	}
+
+	return p
+}
+
+// NewBrowserPoolFromEnv creates a pool configured by the SCFORM_POOL_* variables.
+// It returns nil when SCFORM_POOL_MAX_CONCURRENCY is 0, each retrieval then starts its own browser.
+func NewBrowserPoolFromEnv() *BrowserPool {
+	maxConcurrency := envInt("SCFORM_POOL_MAX_CONCURRENCY", 4)
+	if maxConcurrency <= 0 {
+		return nil
+	}
+
+	return NewBrowserPool(
+		maxConcurrency,
+		envInt("SCFORM_POOL_MAX_USES", 50),
+		envDuration("SCFORM_POOL_HEALTH_INTERVAL", 30*time.Second),
+		connectBrowser,
+	)
+}
+
+// Acquire waits for a free slot and returns a new incognito context.
+// The lease must be released with Release once the caller is done with it.
+func (p *BrowserPool) Acquire(ctx context.Context) (*BrowserLease, error) {
+	p.mu.Lock()
+	p.waiting++
+	p.mu.Unlock()
+
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		p.mu.Lock()
+		p.waiting--
+		p.mu.Unlock()
+		return nil, ctx.Err()
+	}
+
+	p.mu.Lock()
+	p.waiting--
+	p.mu.Unlock()
+
+	lease, err := p.lease(ctx)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return lease, nil
+}
+
+// lease creates an incognito context on the current browser, starting one if needed
+func (p *BrowserPool) lease(ctx context.Context) (*BrowserLease, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for p.current == nil || p.current.retired {
+		if p.connecting == nil {
+			if err := p.start(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Another caller is starting the browser, wait for it without holding the lock
+		connecting := p.connecting
+		p.mu.Unlock()
+		select {
+		case <-connecting:
+		case <-ctx.Done():
+			p.mu.Lock()
+			return nil, ctx.Err()
+		}
+		p.mu.Lock()
+	}
+
+	pooled := p.current
+	incognito, err := pooled.browser.Incognito()
+	if err != nil {
+		// The browser cannot even create a context, consider it dead
+		err = stepError(StepConnect, ErrBrowserUnavailable, err)
+		p.retire(pooled, err)
+		return nil, err
+	}
+
+	pooled.uses++
+	pooled.active++
+	if p.MaxUses > 0 && pooled.uses >= p.MaxUses {
+		DebugLog("Pooled browser reached %d uses, recycling it", pooled.uses)
+		pooled.retired = true
+	}
+
+	return &BrowserLease{
+		Browser: incognito.Context(ctx),
+		pool:    p,
+		pooled:  pooled,
+	}, nil
+}
+
+// start connects a new browser and makes it the current one. p.mu must be held, it is
+// released while connecting so that Stats, Release and the health checks are not blocked meanwhile.
+func (p *BrowserPool) start() error {
+	DebugLog("Starting a new pooled browser")
+	connecting := make(chan struct{})
+	p.connecting = connecting
+	p.mu.Unlock()
+
+	// The browser outlives the retrieval that starts it, so it is bound to the pool context
+	browser, err := p.connect(p.ctx)
+
+	p.mu.Lock()
+	p.connecting = nil
+	close(connecting)
+	if err != nil {
+		return err
+	}
+	if err := p.ctx.Err(); err != nil {
+		// The pool was closed while connecting
+		disconnectBrowser(browser, nil)
+		return err
+	}
+	p.current = &pooledBrowser{browser: browser}
+	return nil
+}
+
+// Release disposes of the incognito context and frees its slot. Passing the error the
+// retrieval ended with lets the pool recycle a browser that became unavailable.
+func (l *BrowserLease) Release(err error) {
+	if l == nil || l.released {
+		return
+	}
+	l.released = true
+
+	// Dispose of the incognito context even if the retrieval was cancelled
+	if closeErr := l.Browser.Context(context.Background()).Timeout(5 * time.Second).Close(); closeErr != nil {
+		DebugLog("Failed to dispose incognito context: %v", closeErr)
+	}
+
+	p := l.pool
+	p.mu.Lock()
+	l.pooled.active--
+	if errors.Is(err, ErrBrowserUnavailable) {
+		log.Printf("Pooled browser reported unavailable, recycling it: %v", err)
+		p.retire(l.pooled, err)
+	} else if l.pooled.retired && l.pooled.active == 0 {
+		p.closeBrowser(l.pooled)
+	}
+	p.mu.Unlock()
+
+	<-p.slots
+}
+
+// Stats returns the current state of the pool
+func (p *BrowserPool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := PoolStats{
+		Active:  len(p.slots),
+		Waiting: p.waiting,
+	}
+	if p.current != nil {
+		stats.Uses = p.current.uses
+	}
+	return stats
+}
+
+// Close closes the pool browser and stops the health checks
+func (p *BrowserPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.current != nil {
+		p.closeBrowser(p.current)
+		p.current = nil
+	}
+	p.cancel()
+}
+
+// retire stops handing out contexts from a browser, closing it once it has no lease left.
+// err is the failure that caused it, if any. p.mu must be held.
+func (p *BrowserPool) retire(pooled *pooledBrowser, err error) {
+	pooled.retired = true
+	if err != nil {
+		pooled.err = err
+	}
+	if p.current == pooled {
+		p.current = nil
+	}
+	if pooled.active == 0 {
+		p.closeBrowser(pooled)
+	}
+}
+
+// closeBrowser closes a pooled browser, p.mu must be held
+func (p *BrowserPool) closeBrowser(pooled *pooledBrowser) {
+	if pooled.browser == nil {
+		return
+	}
+	disconnectBrowser(pooled.browser, pooled.err)
+	pooled.browser = nil
+}
+
+// healthCheckLoop periodically checks the pooled browser still answers, recycling it if not
+func (p *BrowserPool) healthCheckLoop() {
+	ticker := time.NewTicker(p.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		pooled := p.current
+		var browser *rod.Browser
+		if pooled != nil {
+			browser = pooled.browser
+		}
+		p.mu.Unlock()
+		if pooled == nil {
+			continue
+		}
+
+		if err := checkBrowser(browser); err != nil {
+			log.Printf("Pooled browser failed its health check, recycling it: %v", err)
+			p.mu.Lock()
+			p.retire(pooled, stepError(StepConnect, ErrBrowserUnavailable, err))
+			p.mu.Unlock()
+		}
+	}
+}
+
+// checkBrowser reports an error if the browser does not answer a version request
+func checkBrowser(browser *rod.Browser) error {
+	if browser == nil {
+		return fmt.Errorf("browser is closed")
+	}
+	_, err := proto.BrowserGetVersion{}.Call(browser.Timeout(5 * time.Second))
+	return err
+}
@@ -0,0 +1,216 @@
+package scform
+
+import (
+	"sync"
+	"time"
+)
+
+// Steps of each grade source, in the order they run
+var (
+	rodSteps  = []string{StepConnect, StepOpenLogin, StepLogin, StepNavigate, StepDisplayMode, StepExtract, StepAbsences, StepTimetable, StepDocuments}
+	fileSteps = []string{StepExtract}
+)
+
+// Durations of the steps of each grade source, over the past retrievals
+var (
+	rodHistory  = &stepHistory{}
+	fileHistory = &stepHistory{}
+)
+
+// ProgressUpdate is a progress event of a grade retrieval. Sources only set its status and
+// message, the step tracker of the retrieval fills in the step and the progress, and the
+// retry loop the attempt.
+type ProgressUpdate struct {
+	Status   string  `json:"status"`
+	Message  string  `json:"message"`
+	Progress float64 `json:"progress"` // From 0 to 1
+	// Retrieval the update belongs to, set by the web layer so a page can ignore the updates
+	// of a retrieval its session replaced
+	Retrieval string `json:"retrieval,omitempty"`
+
+	Step      string         `json:"step,omitempty"`      // Step in progress, or that failed, one of the Step* constants
+	StepIndex int            `json:"stepIndex,omitempty"` // Position of the step, from 1
+	StepCount int            `json:"stepCount,omitempty"`
+	Steps     []StepProgress `json:"steps,omitempty"` // Every step of the retrieval, in order
+	Attempt   int            `json:"attempt,omitempty"`
+	ETA       float64        `json:"eta,omitempty"` // Seconds left, estimated from past durations, 0 if unknown
+
+	// Retries and queueing
+	Reason      string  `json:"reason,omitempty"` // Why a failed attempt is retried or not
+	Delay       float64 `json:"delay,omitempty"`  // Seconds before the next attempt
+	Position    int     `json:"position,omitempty"`
+	QueueLength int     `json:"queueLength,omitempty"`
+}
+
+// StepProgress is a step of a retrieval as shown in the step list. Steps not started have no
+// start, and steps skipped, like the login when a saved one is resumed, neither.
+type StepProgress struct {
+	Step      string    `json:"step"`
+	StartedAt time.Time `json:"startedAt,omitzero"`
+	EndedAt   time.Time `json:"endedAt,omitzero"`
+}
+
+// stepHistory keeps a moving average of the duration of each step
+type stepHistory struct {
+	mu        sync.Mutex
+	durations map[string]time.Duration
+}
+
+// record folds the duration of a step into its average
+func (h *stepHistory) record(step string, d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.durations == nil {
+		h.durations = make(map[string]time.Duration)
+	}
+	if avg, ok := h.durations[step]; ok {
+		d = time.Duration(0.8*float64(avg) + 0.2*float64(d))
+	}
+	h.durations[step] = d
+}
+
+// estimates returns the average duration of each step, and whether all of them are known
+func (h *stepHistory) estimates(steps []string) ([]time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	estimates := make([]time.Duration, len(steps))
+	known := true
+	for i, step := range steps {
+		d, ok := h.durations[step]
+		estimates[i], known = d, known && ok
+	}
+	return estimates, known
+}
+
+// stepTracker follows the steps of a retrieval and fills in the progress updates of its source
+type stepTracker struct {
+	out     ProgressSink
+	history *stepHistory
+
+	mu      sync.Mutex
+	steps   []StepProgress
+	current int // Index of the step in progress, -1 before the first one
+	done    bool
+}
+
+// newStepTracker creates a tracker for steps, sending the updates it fills in to out
+func newStepTracker(out ProgressSink, steps []string, history *stepHistory) *stepTracker {
+	t := &stepTracker{out: out, history: history, current: -1}
+	for _, step := range steps {
+		t.steps = append(t.steps, StepProgress{Step: step})
+	}
+	return t
+}
+
+// step ends the step in progress and starts the named one, which may come back to an earlier
+// step, like the login after a saved one was refused
+func (t *stepTracker) step(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.end()
+	for i := range t.steps {
+		if t.steps[i].Step == name {
+			t.current = i
+			t.steps[i] = StepProgress{Step: name, StartedAt: time.Now()}
+			return
+		}
+	}
+	DebugLog("Step %s is not tracked", name)
+}
+
+// finish ends the last step, the updates sent next report the retrieval as done
+func (t *stepTracker) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.end()
+	t.done = true
+}
+
+// end ends the step in progress, recording its duration
+func (t *stepTracker) end() {
+	if t.current < 0 || !t.steps[t.current].EndedAt.IsZero() {
+		return
+	}
+	step := &t.steps[t.current]
+	step.EndedAt = time.Now()
+	t.history.record(step.Step, step.EndedAt.Sub(step.StartedAt))
+}
+
+// sink returns a ProgressSink filling in the updates before sending them on
+func (t *stepTracker) sink() ProgressSink {
+	return func(update ProgressUpdate) {
+		t.fill(&update)
+		t.out.Send(update)
+	}
+}
+
+// fill sets the step, progress and estimated time left of an update
+func (t *stepTracker) fill(update *ProgressUpdate) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	update.StepCount = len(t.steps)
+	update.Steps = append([]StepProgress(nil), t.steps...)
+	if t.current >= 0 {
+		update.Step = t.steps[t.current].Step
+		update.StepIndex = t.current + 1
+	}
+	if t.done {
+		update.Progress, update.ETA = 1, 0
+		return
+	}
+	if t.current < 0 {
+		update.Progress = 0
+		return
+	}
+
+	// Steps weigh their average duration once all are known, they weigh the same until then
+	estimates, known := t.history.estimates(stepNames(t.steps))
+	weights := make([]float64, len(t.steps))
+	for i := range weights {
+		weights[i] = 1
+		if known && estimates[i] > 0 {
+			weights[i] = estimates[i].Seconds()
+		}
+	}
+	var total, before float64
+	for i, weight := range weights {
+		total += weight
+		if i < t.current {
+			before += weight
+		}
+	}
+
+	// The step in progress counts for the share of its average it has taken, up to most of it
+	elapsed := time.Since(t.steps[t.current].StartedAt)
+	share := 0.0
+	if estimate := estimates[t.current]; estimate > 0 {
+		share = min(elapsed.Seconds()/estimate.Seconds(), 0.9)
+	}
+	update.Progress = (before + share*weights[t.current]) / total
+
+	// The time left is only estimated once every step left has been timed
+	eta := max(estimates[t.current]-elapsed, 0)
+	for i := t.current + 1; i < len(t.steps); i++ {
+		if estimates[i] == 0 {
+			return
+		}
+		eta += estimates[i]
+	}
+	if estimates[t.current] > 0 {
+		update.ETA = eta.Seconds()
+	}
+}
+
+// stepNames returns the name of each step
+func stepNames(steps []StepProgress) []string {
+	names := make([]string, len(steps))
+	for i, step := range steps {
+		names[i] = step.Step
+	}
+	return names
+}
@@ -0,0 +1,109 @@
+package scform
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestStepTrackerFill(t *testing.T) {
+	steps := []string{StepLogin, StepExtract, StepAbsences}
+	timed := map[string]time.Duration{StepLogin: time.Second, StepExtract: 2 * time.Second, StepAbsences: 7 * time.Second}
+	partly := map[string]time.Duration{StepLogin: time.Second, StepExtract: 2 * time.Second}
+
+	tests := []struct {
+		name      string
+		history   map[string]time.Duration
+		current   int           // Step in progress, -1 before the first one
+		elapsed   time.Duration // Time spent in the step in progress
+		done      bool
+		stepIndex int
+		progress  float64
+		eta       float64
+	}{
+		{name: "not started", history: timed, current: -1},
+		// Steps weigh the same until they have all been timed
+		{name: "no history", current: 1, elapsed: time.Second, stepIndex: 2, progress: 1.0 / 3},
+		{name: "partial history", history: partly, current: 1, elapsed: time.Second, stepIndex: 2, progress: 1.5 / 3},
+		{name: "halfway through a step", history: timed, current: 1, elapsed: time.Second, stepIndex: 2, progress: 0.2, eta: 8},
+		{name: "step overrunning its average", history: timed, current: 1, elapsed: 5 * time.Second, stepIndex: 2, progress: 0.28, eta: 7},
+		{name: "last step", history: timed, current: 2, elapsed: 0, stepIndex: 3, progress: 0.3, eta: 7},
+		{name: "done", history: timed, current: 2, done: true, stepIndex: 3, progress: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newStepTracker(nil, steps, &stepHistory{durations: tt.history})
+			now := time.Now()
+			for i := 0; i <= tt.current; i++ {
+				tracker.steps[i].StartedAt = now.Add(-time.Minute)
+				if i < tt.current || tt.done {
+					tracker.steps[i].EndedAt = now
+				}
+			}
+			if tt.current >= 0 {
+				tracker.steps[tt.current].StartedAt = now.Add(-tt.elapsed)
+			}
+			tracker.current, tracker.done = tt.current, tt.done
+
+			var update ProgressUpdate
+			tracker.fill(&update)
+
+			if update.StepCount != len(steps) || len(update.Steps) != len(steps) {
+				t.Errorf("%d steps counted and %d listed, want %d", update.StepCount, len(update.Steps), len(steps))
+			}
+			if update.StepIndex != tt.stepIndex {
+				t.Errorf("step index %d, want %d", update.StepIndex, tt.stepIndex)
+			}
+			if tt.stepIndex > 0 && update.Step != steps[tt.stepIndex-1] {
+				t.Errorf("step %q, want %q", update.Step, steps[tt.stepIndex-1])
+			}
+			// The step in progress goes on while the test runs
+			if math.Abs(update.Progress-tt.progress) > 0.01 {
+				t.Errorf("progress %.3f, want %.3f", update.Progress, tt.progress)
+			}
+			if math.Abs(update.ETA-tt.eta) > 0.1 {
+				t.Errorf("ETA %.2fs, want %.2fs", update.ETA, tt.eta)
+			}
+		})
+	}
+}
+
+func TestStepTrackerStep(t *testing.T) {
+	history := &stepHistory{}
+	var updates []ProgressUpdate
+	tracker := newStepTracker(func(update ProgressUpdate) { updates = append(updates, update) }, []string{StepNavigate, StepOpenLogin, StepLogin}, history)
+	send := tracker.sink()
+
+	// A refused saved login goes back to the login page
+	tracker.step(StepNavigate)
+	tracker.step(StepOpenLogin)
+	tracker.step(StepLogin)
+	tracker.step(StepOpenLogin)
+	send(ProgressUpdate{Status: "navigating"})
+
+	if len(updates) != 1 {
+		t.Fatalf("%d updates sent, want 1", len(updates))
+	}
+	update := updates[0]
+	if update.Status != "navigating" || update.Step != StepOpenLogin || update.StepIndex != 2 {
+		t.Errorf("update %+v, want the navigating status at step 2 %s", update, StepOpenLogin)
+	}
+	if login := update.Steps[2]; login.StartedAt.IsZero() || login.EndedAt.IsZero() {
+		t.Errorf("ended login step %+v", login)
+	}
+	if again := update.Steps[1]; again.StartedAt.IsZero() || !again.EndedAt.IsZero() {
+		t.Errorf("restarted step %+v, want it in progress", again)
+	}
+	if _, known := history.estimates([]string{StepNavigate, StepOpenLogin, StepLogin}); !known {
+		t.Error("ended steps not recorded in the history")
+	}
+
+	// Untracked steps are only logged
+	tracker.step(StepDocuments)
+	tracker.finish()
+	send(ProgressUpdate{Status: "complete"})
+	if last := updates[len(updates)-1]; last.Progress != 1 || last.ETA != 0 {
+		t.Errorf("finished update %+v, want full progress", last)
+	}
+}
@@ -0,0 +1,350 @@
+package scform
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"scrapping/internals/utils"
+
+	"github.com/go-rod/rod"
+)
+
+// Remote browser selection strategies
+const (
+	SelectLeastBusy  = "least-busy"
+	SelectRoundRobin = "round-robin"
+)
+
+// RemoteRegistry routes browser connections across the endpoints of SCFORM_REMOTE_URL.
+// Each endpoint is health-checked periodically, and an endpoint that fails a check or a
+// connection is left out for Cooldown before it is tried again.
+type RemoteRegistry struct {
+	Selection           string        // SelectLeastBusy or SelectRoundRobin
+	Cooldown            time.Duration // How long a failing endpoint is left out
+	HealthCheckInterval time.Duration // How often endpoints are checked, 0 to disable
+
+	mu        sync.Mutex
+	endpoints []*remoteEndpoint
+	browsers  map[*rod.Browser]*remoteEndpoint // Connected browsers and their endpoint
+	next      int                              // Round-robin cursor
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+// remoteEndpoint is a remote browser endpoint with its health
+type remoteEndpoint struct {
+	url       string
+	active    int
+	failures  int
+	downUntil time.Time
+	lastCheck time.Time
+	lastErr   error
+}
+
+// RemoteEndpointState describes a remote browser endpoint
+type RemoteEndpointState struct {
+	URL       string     `json:"url"`                 // Endpoint URL, without its query string which may hold a token
+	Healthy   bool       `json:"healthy"`             // Whether connections are routed to it
+	Active    int        `json:"active"`              // Browsers currently connected through it
+	Failures  int        `json:"failures"`            // Consecutive failed checks or connections
+	DownUntil *time.Time `json:"downUntil,omitempty"` // End of the cooldown of a failing endpoint
+	LastCheck *time.Time `json:"lastCheck,omitempty"` // Time of the last health check
+	LastError string     `json:"lastError,omitempty"` // Error of the last failed check or connection
+}
+
+var (
+	remoteBrowsersOnce sync.Once
+	remoteBrowsers     *RemoteRegistry
+)
+
+// RemoteBrowsers returns the registry of the SCFORM_REMOTE_URL endpoints, or nil when
+// browsers are launched locally. It is created on first use.
+func RemoteBrowsers() *RemoteRegistry {
+	remoteBrowsersOnce.Do(func() {
+		remoteBrowsers = NewRemoteRegistryFromEnv()
+	})
+	return remoteBrowsers
+}
+
+// NewRemoteRegistry creates a registry for the given endpoints and starts its health checks
+func NewRemoteRegistry(urls []string, selection string, cooldown, healthCheckInterval time.Duration) *RemoteRegistry {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &RemoteRegistry{
+		Selection:           selection,
+		Cooldown:            cooldown,
+		HealthCheckInterval: healthCheckInterval,
+		browsers:            make(map[*rod.Browser]*remoteEndpoint),
+		ctx:                 ctx,
+		cancel:              cancel,
+	}
+	for _, u := range urls {
+		if u = strings.TrimSpace(u); u != "" {
+			r.endpoints = append(r.endpoints, &remoteEndpoint{url: u})
+		}
+	}
+
+	if healthCheckInterval > 0 {
+		go r.healthCheckLoop()
+	}
+	return r
+}
+
+// NewRemoteRegistryFromEnv creates a registry from SCFORM_REMOTE_URL, a comma-separated list of
+// endpoints, configured by the SCFORM_REMOTE_* variables. It returns nil when no endpoint is set.
+func NewRemoteRegistryFromEnv() *RemoteRegistry {
+	remoteURL := os.Getenv("SCFORM_REMOTE_URL")
+	if strings.TrimSpace(remoteURL) == "" {
+		return nil
+	}
+
+	selection := os.Getenv("SCFORM_REMOTE_SELECTION")
+	switch selection {
+	case SelectLeastBusy, SelectRoundRobin:
+	case "":
+		selection = SelectLeastBusy
+	default:
+		log.Printf("Invalid SCFORM_REMOTE_SELECTION %q, using %s", selection, SelectLeastBusy)
+		selection = SelectLeastBusy
+	}
+
+	r := NewRemoteRegistry(
+		strings.Split(remoteURL, ","),
+		selection,
+		envDuration("SCFORM_REMOTE_COOLDOWN", 30*time.Second),
+		envDuration("SCFORM_REMOTE_HEALTH_INTERVAL", 15*time.Second),
+	)
+	log.Printf("Routing browsers to %d remote endpoint(s), %s selection", len(r.endpoints), r.Selection)
+	return r
+}
+
+// Connect connects to a healthy endpoint, failing over to the next one when a connection fails
+func (r *RemoteRegistry) Connect(ctx context.Context) (*rod.Browser, error) {
+	tried := make(map[*remoteEndpoint]bool)
+	var lastErr error
+
+	for {
+		endpoint := r.pick(tried)
+		if endpoint == nil {
+			break
+		}
+		tried[endpoint] = true
+
+		DebugLog("Connecting to remote browser: %s", redactURL(endpoint.url))
+		browser, err := dialRemote(ctx, endpoint.url)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, stepError(StepConnect, ErrBrowserUnavailable, ctx.Err())
+			}
+			r.markDown(endpoint, err)
+			lastErr = err
+			continue
+		}
+
+		r.mu.Lock()
+		endpoint.active++
+		endpoint.failures = 0
+		r.browsers[browser] = endpoint
+		r.mu.Unlock()
+		return browser, nil
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no healthy remote browser among %d endpoint(s)", len(r.endpoints))
+	}
+	return nil, stepError(StepConnect, ErrBrowserUnavailable, lastErr)
+}
+
+// Release forgets a browser connected by the registry, called once it is closed.
+// Passing the error it failed with, if any, marks its endpoint down.
+func (r *RemoteRegistry) Release(browser *rod.Browser, err error) {
+	r.mu.Lock()
+	endpoint, ok := r.browsers[browser]
+	if ok {
+		delete(r.browsers, browser)
+		endpoint.active--
+	}
+	r.mu.Unlock()
+
+	if ok && err != nil {
+		r.markDown(endpoint, err)
+	}
+}
+
+// States returns the state of every endpoint
+func (r *RemoteRegistry) States() []RemoteEndpointState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	states := make([]RemoteEndpointState, 0, len(r.endpoints))
+	for _, endpoint := range r.endpoints {
+		state := RemoteEndpointState{
+			URL:      redactURL(endpoint.url),
+			Healthy:  !now.Before(endpoint.downUntil),
+			Active:   endpoint.active,
+			Failures: endpoint.failures,
+		}
+		if !state.Healthy {
+			downUntil := endpoint.downUntil
+			state.DownUntil = &downUntil
+		}
+		if !endpoint.lastCheck.IsZero() {
+			lastCheck := endpoint.lastCheck
+			state.LastCheck = &lastCheck
+		}
+		if endpoint.lastErr != nil {
+			state.LastError = endpoint.lastErr.Error()
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// Close stops the health checks
+func (r *RemoteRegistry) Close() {
+	r.cancel()
+}
+
+// pick selects the next healthy endpoint not tried yet, or nil if there is none
+func (r *RemoteRegistry) pick(tried map[*remoteEndpoint]bool) *remoteEndpoint {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var best *remoteEndpoint
+	bestIndex := 0
+
+	// Walk the endpoints from the round-robin cursor so ties go to the next one in turn
+	for i := range r.endpoints {
+		index := (r.next + i) % len(r.endpoints)
+		endpoint := r.endpoints[index]
+		if tried[endpoint] || now.Before(endpoint.downUntil) {
+			continue
+		}
+		if best == nil || (r.Selection == SelectLeastBusy && endpoint.active < best.active) {
+			best, bestIndex = endpoint, index
+		}
+		if r.Selection == SelectRoundRobin {
+			break
+		}
+	}
+
+	if best != nil {
+		r.next = bestIndex + 1
+	}
+	return best
+}
+
+// markDown leaves a failing endpoint out for the cooldown
+func (r *RemoteRegistry) markDown(endpoint *remoteEndpoint, err error) {
+	r.mu.Lock()
+	endpoint.failures++
+	endpoint.lastErr = err
+	endpoint.downUntil = time.Now().Add(r.Cooldown)
+	r.mu.Unlock()
+
+	log.Printf("Remote browser %s marked down for %s: %v", redactURL(endpoint.url), r.Cooldown, err)
+}
+
+// healthCheckLoop periodically checks every endpoint
+func (r *RemoteRegistry) healthCheckLoop() {
+	ticker := time.NewTicker(r.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var wg sync.WaitGroup
+		for _, endpoint := range r.endpoints {
+			wg.Add(1)
+			go func(endpoint *remoteEndpoint) {
+				defer wg.Done()
+
+				ctx, cancel := context.WithTimeout(r.ctx, 5*time.Second)
+				err := checkRemote(ctx, endpoint.url)
+				cancel()
+
+				if err != nil {
+					r.markDown(endpoint, err)
+					r.mu.Lock()
+					endpoint.lastCheck = time.Now()
+					r.mu.Unlock()
+					return
+				}
+
+				// A passing check does not cut a cooldown short, so a flapping endpoint stays out
+				r.mu.Lock()
+				endpoint.lastCheck = time.Now()
+				endpoint.failures = 0
+				endpoint.lastErr = nil
+				r.mu.Unlock()
+			}(endpoint)
+		}
+		wg.Wait()
+	}
+}
+
+// dialRemote connects to a remote browser
+func dialRemote(ctx context.Context, controlURL string) (*rod.Browser, error) {
+	browser := rod.New().ControlURL(controlURL).Context(ctx)
+	if err := browser.Connect(); err != nil {
+		return nil, err
+	}
+	if err := browser.IgnoreCertErrors(true); err != nil {
+		browser.Context(context.Background()).Close()
+		return nil, err
+	}
+	return browser.NoDefaultDevice(), nil
+}
+
+// checkRemote reports an error if the endpoint does not accept DevTools connections.
+// WebSocket endpoints are dialled, HTTP ones must answer /json/version.
+func checkRemote(ctx context.Context, endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+
+	switch u.Scheme {
+	case "ws", "wss":
+		return utils.TestChromeDevWSContext(ctx, endpoint)
+	case "http", "https":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/json/version"}).String(), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("/json/version returned %s", resp.Status)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported remote browser scheme %q", u.Scheme)
+	}
+}
+
+// redactURL drops the query string of an endpoint URL, where tokens are usually passed
+func redactURL(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "<invalid url>"
+	}
+	u.RawQuery = ""
+	u.User = nil
+	return u.String()
+}
@@ -0,0 +1,369 @@
+package scform
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// unsafeFileChars matches the characters replaced in archive file names
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// SessionArchive is a HAR-like record of the HTTP exchanges of a scrape. Recorded with
+// RodSource.RecordDir, it can be served back with RodSource.ReplayPath to run the same
+// scrape offline. Credentials and session cookies are redacted, but responses keep the
+// student's grades and details, so archives must be handled as sensitive.
+type SessionArchive struct {
+	Log archiveLog `json:"log"`
+
+	mu     sync.Mutex
+	served map[string]int // Entries already served by request key, for replay
+}
+
+type archiveLog struct {
+	Version string         `json:"version"`
+	Creator archiveCreator `json:"creator"`
+	Entries []archiveEntry `json:"entries"`
+}
+
+type archiveCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type archiveEntry struct {
+	StartedDateTime time.Time       `json:"startedDateTime"`
+	Time            float64         `json:"time"` // Milliseconds
+	Request         archiveRequest  `json:"request"`
+	Response        archiveResponse `json:"response"`
+}
+
+type archiveRequest struct {
+	Method   string           `json:"method"`
+	URL      string           `json:"url"`
+	Headers  []archiveHeader  `json:"headers"`
+	PostData *archivePostData `json:"postData,omitempty"`
+}
+
+type archivePostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type archiveResponse struct {
+	Status     int             `json:"status"`
+	StatusText string          `json:"statusText"`
+	Headers    []archiveHeader `json:"headers"`
+	Content    archiveContent  `json:"content"`
+}
+
+type archiveHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type archiveContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"` // "base64" for binary bodies
+}
+
+// LoadSessionArchive reads an archive recorded by a RodSource
+func LoadSessionArchive(path string) (*SessionArchive, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session archive: %v", err)
+	}
+
+	archive := &SessionArchive{}
+	if err := json.Unmarshal(data, archive); err != nil {
+		return nil, fmt.Errorf("failed to parse session archive %s: %v", path, err)
+	}
+	return archive, nil
+}
+
+// Save writes the archive as indented JSON
+func (a *SessionArchive) Save(path string) error {
+	a.mu.Lock()
+	data, err := json.MarshalIndent(a, "", "  ")
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// add appends a recorded exchange
+func (a *SessionArchive) add(entry archiveEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Log.Entries = append(a.Log.Entries, entry)
+}
+
+// next returns the entry to replay for a request. Exchanges recorded for the same method and
+// URL, like WebForms postbacks, are served in their recorded order, the last one being repeated.
+// URLs are matched without their query string when there is no exact match, so cache busters
+// do not break the replay.
+func (a *SessionArchive) next(method, requestURL string) *archiveEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.served == nil {
+		a.served = make(map[string]int)
+	}
+
+	for _, exact := range []bool{true, false} {
+		var matches []*archiveEntry
+		for i := range a.Log.Entries {
+			entry := &a.Log.Entries[i]
+			if entry.Request.Method == method && sameURL(entry.Request.URL, requestURL, exact) {
+				matches = append(matches, entry)
+			}
+		}
+		if len(matches) == 0 {
+			continue
+		}
+
+		key := fmt.Sprintf("%t %s %s", exact, method, requestURL)
+		index := a.served[key]
+		a.served[key]++
+		if index >= len(matches) {
+			index = len(matches) - 1
+		}
+		return matches[index]
+	}
+	return nil
+}
+
+// sameURL compares two URLs, ignoring their query strings unless exact
+func sameURL(a, b string, exact bool) bool {
+	if exact {
+		return a == b
+	}
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return ua.Scheme == ub.Scheme && ua.Host == ub.Host && ua.Path == ub.Path
+}
+
+// startSession records or replays the HTTP exchanges of the browser, as configured, the filter
+// blocking requests before they are recorded or replayed. The returned function stops it and,
+// when recording, writes the archive.
+func (s *RodSource) startSession(browser *rod.Browser, creds Credentials, filter *filterSession) (func(), error) {
+	switch {
+	case s.ReplayPath != "":
+		archive, err := LoadSessionArchive(s.ReplayPath)
+		if err != nil {
+			return nil, err
+		}
+		DebugLog("Replaying %d recorded exchanges from %s", len(archive.Log.Entries), s.ReplayPath)
+		return hijackSession(browser, filter.wrap(archive.replay))
+	case s.RecordDir != "":
+		archive := &SessionArchive{Log: archiveLog{
+			Version: "1.2",
+			Creator: archiveCreator{Name: "scform", Version: "1"},
+		}}
+		record, err := archive.recorder(creds)
+		if err != nil {
+			return nil, err
+		}
+		stop, err := hijackSession(browser, filter.wrap(record))
+		if err != nil {
+			return nil, err
+		}
+
+		name := unsafeFileChars.ReplaceAllString(creds.Username, "_")
+		path := filepath.Join(s.RecordDir, fmt.Sprintf("%s-%s.har", name, time.Now().Format("20060102-150405")))
+		return func() {
+			stop()
+			if err := archive.Save(path); err != nil {
+				log.Printf("Failed to save session archive: %v", err)
+				return
+			}
+			log.Printf("Recorded %d exchanges to %s", len(archive.Log.Entries), path)
+		}, nil
+	default:
+		return func() {}, nil
+	}
+}
+
+// hijackSession routes every request of the browser to handler until the returned function is called
+func hijackSession(browser *rod.Browser, handler func(*rod.Hijack)) (func(), error) {
+	// The router outlives the calls bound to the retrieval deadline, it is stopped explicitly
+	router := browser.Context(context.Background()).HijackRequests()
+	if err := router.Add("*", "", handler); err != nil {
+		return nil, err
+	}
+	go router.Run()
+
+	return func() {
+		if err := router.Stop(); err != nil {
+			DebugLog("Failed to stop request hijacking: %v", err)
+		}
+	}, nil
+}
+
+// recorder returns a hijack handler sending requests to the network and archiving the exchanges
+func (a *SessionArchive) recorder(creds Credentials) (func(*rod.Hijack), error) {
+	// Paused requests do not carry the browser cookies, the client keeps the session itself
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{
+		Jar:     jar,
+		Timeout: 30 * time.Second,
+		// Redirects are left to the browser so each hop is recorded
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	redact := (&diagnosticRecorder{creds: creds}).redact
+
+	return func(h *rod.Hijack) {
+		started := time.Now()
+
+		// Let the client negotiate and decode the compression so archived bodies are readable
+		req := h.Request.Req()
+		req.Header.Del("Accept-Encoding")
+
+		if err := h.LoadResponse(client, true); err != nil {
+			DebugLog("Failed to load %s: %v", req.URL, err)
+			h.Response.Fail(proto.NetworkErrorReasonConnectionFailed)
+			return
+		}
+
+		a.add(archiveExchange(req, h.Request.Body(), h.Response.Payload().ResponseCode, h.Response.Headers(), h.Response.Payload().Body, started, redact))
+	}, nil
+}
+
+// archiveExchange builds the archive entry of an exchange, redacting the credentials and session
+// cookies it carries
+func archiveExchange(req *http.Request, requestBody string, status int, header http.Header, body []byte, started time.Time, redact func(string) string) archiveEntry {
+	entry := archiveEntry{
+		StartedDateTime: started,
+		Time:            float64(time.Since(started).Microseconds()) / 1000,
+		Request: archiveRequest{
+			Method:  req.Method,
+			URL:     req.URL.String(),
+			Headers: archiveHeaders(req.Header, redact),
+		},
+		Response: archiveResponse{
+			Status:     status,
+			StatusText: http.StatusText(status),
+			Headers:    archiveHeaders(header, redact),
+		},
+	}
+	if requestBody != "" {
+		entry.Request.PostData = &archivePostData{
+			MimeType: req.Header.Get("Content-Type"),
+			Text:     redact(requestBody),
+		}
+	}
+
+	entry.Response.Content = archiveContent{
+		Size:     len(body),
+		MimeType: header.Get("Content-Type"),
+	}
+	if utf8.Valid(body) {
+		entry.Response.Content.Text = string(body)
+	} else {
+		entry.Response.Content.Text = base64.StdEncoding.EncodeToString(body)
+		entry.Response.Content.Encoding = "base64"
+	}
+	return entry
+}
+
+// replay is a hijack handler serving recorded responses, failing requests that were not recorded
+func (a *SessionArchive) replay(h *rod.Hijack) {
+	method, requestURL := h.Request.Method(), h.Request.URL().String()
+
+	entry := a.next(method, requestURL)
+	if entry == nil {
+		DebugLog("No recorded response for %s %s", method, requestURL)
+		h.Response.Fail(proto.NetworkErrorReasonInternetDisconnected)
+		return
+	}
+
+	h.Response.Payload().ResponseCode = entry.Response.Status
+	for _, header := range entry.Response.Headers {
+		// The body is archived decoded and may have been re-encoded as base64
+		if strings.EqualFold(header.Name, "Content-Length") || strings.EqualFold(header.Name, "Content-Encoding") {
+			continue
+		}
+		h.Response.SetHeader(header.Name, header.Value)
+	}
+
+	body := []byte(entry.Response.Content.Text)
+	if entry.Response.Content.Encoding == "base64" {
+		decoded, err := base64.StdEncoding.DecodeString(entry.Response.Content.Text)
+		if err != nil {
+			DebugLog("Failed to decode recorded body of %s: %v", requestURL, err)
+		}
+		body = decoded
+	}
+	h.Response.SetBody(body)
+}
+
+// archiveHeaders converts headers for the archive, redacting the credentials they may carry.
+// Set cookies keep their name and attributes, a replay not needing their value.
+func archiveHeaders(header http.Header, redact func(string) string) []archiveHeader {
+	var headers []archiveHeader
+	// Sorted so archives of the same scrape diff cleanly
+	for _, name := range slices.Sorted(maps.Keys(header)) {
+		for _, value := range header[name] {
+			switch strings.ToLower(name) {
+			case "cookie", "authorization":
+				value = redactedText
+			case "set-cookie":
+				cookie, attributes, _ := strings.Cut(value, ";")
+				if cookieName, _, ok := strings.Cut(cookie, "="); ok {
+					value = cookieName + "=" + redactedText
+				} else {
+					value = redactedText
+				}
+				if attributes != "" {
+					value += ";" + attributes
+				}
+			default:
+				value = redact(value)
+			}
+			headers = append(headers, archiveHeader{Name: name, Value: value})
+		}
+	}
+	return headers
+}
+
+// sessionFilesFromEnv sets the record and replay paths from SCFORM_RECORD_DIR and SCFORM_REPLAY_PATH
+func (s *RodSource) sessionFilesFromEnv() error {
+	s.RecordDir = os.Getenv("SCFORM_RECORD_DIR")
+	s.ReplayPath = os.Getenv("SCFORM_REPLAY_PATH")
+	if s.RecordDir != "" && s.ReplayPath != "" {
+		return fmt.Errorf("SCFORM_RECORD_DIR and SCFORM_REPLAY_PATH cannot both be set")
+	}
+	return nil
+}
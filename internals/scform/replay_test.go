@@ -0,0 +1,144 @@
+package scform
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionArchiveNext(t *testing.T) {
+	entry := func(method, url, body string) archiveEntry {
+		return archiveEntry{
+			Request:  archiveRequest{Method: method, URL: url},
+			Response: archiveResponse{Status: http.StatusOK, Content: archiveContent{Text: body}},
+		}
+	}
+	archive := &SessionArchive{Log: archiveLog{Entries: []archiveEntry{
+		entry("GET", "https://sc.example/Eleve/MesNotes.aspx", "notes"),
+		entry("POST", "https://sc.example/Eleve/MesNotes.aspx", "postback 1"),
+		entry("POST", "https://sc.example/Eleve/MesNotes.aspx", "postback 2"),
+		entry("GET", "https://sc.example/app.js?v=1", "script v1"),
+		entry("GET", "https://sc.example/app.js?v=2", "script v2"),
+	}}}
+
+	// Requests are replayed in order, each one moving its own match along
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		want   string // Body served, "" when nothing is
+	}{
+		{"page", "GET", "https://sc.example/Eleve/MesNotes.aspx", "notes"},
+		{"first postback", "POST", "https://sc.example/Eleve/MesNotes.aspx", "postback 1"},
+		{"second postback", "POST", "https://sc.example/Eleve/MesNotes.aspx", "postback 2"},
+		{"entries run out, the last is repeated", "POST", "https://sc.example/Eleve/MesNotes.aspx", "postback 2"},
+		{"page again, repeated", "GET", "https://sc.example/Eleve/MesNotes.aspx", "notes"},
+		{"exact query", "GET", "https://sc.example/app.js?v=2", "script v2"},
+		{"other query", "GET", "https://sc.example/app.js?v=3", "script v1"},
+		{"other query again", "GET", "https://sc.example/app.js?v=3", "script v2"},
+		{"other query, entries run out", "GET", "https://sc.example/app.js?v=3", "script v2"},
+		{"exact query, counted apart", "GET", "https://sc.example/app.js?v=1", "script v1"},
+		{"other method", "PUT", "https://sc.example/Eleve/MesNotes.aspx", ""},
+		{"other host", "GET", "https://other.example/app.js?v=1", ""},
+		{"other path", "GET", "https://sc.example/Eleve/MesAbsences.aspx", ""},
+	}
+
+	for _, tt := range tests {
+		got := archive.next(tt.method, tt.url)
+		switch {
+		case got == nil && tt.want != "":
+			t.Errorf("%s: nothing served for %s %s, want %q", tt.name, tt.method, tt.url, tt.want)
+		case got != nil && got.Response.Content.Text != tt.want:
+			t.Errorf("%s: served %q for %s %s, want %q", tt.name, got.Response.Content.Text, tt.method, tt.url, tt.want)
+		}
+	}
+}
+
+func TestSessionArchiveRoundTrip(t *testing.T) {
+	creds := Credentials{URL: "https://sc.example/login", Username: "jane.doe@example.com", Password: "s3cret!"}
+	redact := (&diagnosticRecorder{creds: creds}).redact
+
+	req, err := http.NewRequest("POST", "https://sc.example/api/login", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Cookie", "ASP.NET_SessionId=abc123; .ASPXAUTH=def456")
+	req.Header.Set("Authorization", "Bearer tok3n")
+	req.Header.Set("Referer", "https://sc.example/login?email=jane.doe%40example.com")
+	header := http.Header{}
+	header.Add("Set-Cookie", ".ASPXAUTH=def456; path=/; HttpOnly")
+	header.Add("Set-Cookie", "ASP.NET_SessionId=abc123")
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	body := []byte("<html>Bienvenue</html>")
+
+	recorded := &SessionArchive{}
+	recorded.add(archiveExchange(req, "email=jane.doe%40example.com&password=s3cret%21", http.StatusOK, header, body, time.Now(), redact))
+	recorded.add(archiveExchange(req, "", http.StatusOK, http.Header{}, []byte{0xff, 0x00, 0xfe}, time.Now(), redact))
+
+	path := filepath.Join(t.TempDir(), "records", "session.har")
+	if err := recorded.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"abc123", "def456", "tok3n", "s3cret", "jane.doe"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("archive shows %q:\n%s", secret, data)
+		}
+	}
+
+	archive, err := LoadSessionArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := archive.next("POST", "https://sc.example/api/login")
+	if entry == nil {
+		t.Fatal("recorded exchange not replayed")
+	}
+
+	want := map[string][]string{
+		"Authorization": {redactedText},
+		"Content-Type":  {"application/x-www-form-urlencoded"},
+		"Cookie":        {redactedText},
+		"Referer":       {"https://sc.example/login?email=" + redactedUser},
+	}
+	checkHeaders(t, "request", entry.Request.Headers, want)
+	checkHeaders(t, "response", entry.Response.Headers, map[string][]string{
+		"Content-Type": {"text/html; charset=utf-8"},
+		"Set-Cookie":   {".ASPXAUTH=" + redactedText + "; path=/; HttpOnly", "ASP.NET_SessionId=" + redactedText},
+	})
+	if entry.Request.PostData == nil || entry.Request.PostData.Text != "email="+redactedUser+"&password="+redactedText {
+		t.Errorf("request body %+v", entry.Request.PostData)
+	}
+	if entry.Response.Content.Text != string(body) || entry.Response.Content.Encoding != "" {
+		t.Errorf("response body %+v, want %q", entry.Response.Content, body)
+	}
+
+	// Binary bodies go through base64
+	if binary := archive.next("POST", "https://sc.example/api/login"); binary == nil || binary.Response.Content.Encoding != "base64" || binary.Response.Content.Size != 3 {
+		t.Errorf("binary response %+v", binary)
+	}
+}
+
+// checkHeaders compares archived headers with the values expected for each name
+func checkHeaders(t *testing.T, what string, headers []archiveHeader, want map[string][]string) {
+	t.Helper()
+	got := make(map[string][]string)
+	for _, header := range headers {
+		got[header.Name] = append(got[header.Name], header.Value)
+	}
+	if len(got) != len(want) {
+		t.Errorf("%s headers %v, want %v", what, got, want)
+	}
+	for name, values := range want {
+		if strings.Join(got[name], "\n") != strings.Join(values, "\n") {
+			t.Errorf("%s header %s = %q, want %q", what, name, got[name], values)
+		}
+	}
+}
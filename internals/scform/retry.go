@@ -0,0 +1,156 @@
+package scform
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RetryPolicy decides whether a failed grade retrieval is tried again and after how long.
+// Invalid credentials and layout changes fail fast, transient browser and network errors
+// back off exponentially with jitter, within a retry budget shared by all users of a host.
+type RetryPolicy struct {
+	MaxAttempts  int           // Attempts per retrieval, including the first one
+	BaseDelay    time.Duration // Delay before the first retry, doubled on each retry
+	MaxDelay     time.Duration // Upper bound of the delay between attempts
+	HostBudget   int           // Retries allowed per host within BudgetWindow, 0 for no limit
+	BudgetWindow time.Duration // Sliding window of the host retry budget
+
+	mu      sync.Mutex
+	retries map[string][]time.Time // Recent retries by host
+}
+
+// RetryDecision is the outcome of RetryPolicy.Decide
+type RetryDecision struct {
+	Retry  bool          // Whether to try again
+	Delay  time.Duration // How long to wait before trying again
+	Reason string        // Why, for logs and progress updates
+}
+
+// DefaultRetryPolicy returns the policy used when nothing is configured
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:  3,
+		BaseDelay:    2 * time.Second,
+		MaxDelay:     30 * time.Second,
+		HostBudget:   20,
+		BudgetWindow: time.Minute,
+	}
+}
+
+// RetryPolicyFromEnv returns the default policy overridden by the SCFORM_RETRY_* variables
+func RetryPolicyFromEnv() *RetryPolicy {
+	p := DefaultRetryPolicy()
+	p.MaxAttempts = envInt("SCFORM_RETRY_MAX_ATTEMPTS", p.MaxAttempts)
+	p.BaseDelay = envDuration("SCFORM_RETRY_BASE_DELAY", p.BaseDelay)
+	p.MaxDelay = envDuration("SCFORM_RETRY_MAX_DELAY", p.MaxDelay)
+	p.HostBudget = envInt("SCFORM_RETRY_HOST_BUDGET", p.HostBudget)
+	p.BudgetWindow = envDuration("SCFORM_RETRY_BUDGET_WINDOW", p.BudgetWindow)
+	return p
+}
+
+// Decide tells whether attempt, which failed with err against host, should be retried.
+// A positive decision consumes one retry of the host budget.
+func (p *RetryPolicy) Decide(host string, attempt int, err error) RetryDecision {
+	switch {
+	case err == nil:
+		return RetryDecision{Reason: "succeeded"}
+	case errors.Is(err, context.Canceled):
+		return RetryDecision{Reason: "cancelled"}
+	case errors.Is(err, ErrInvalidCredentials):
+		return RetryDecision{Reason: "invalid credentials are not retried"}
+	case errors.Is(err, ErrLayoutChanged):
+		return RetryDecision{Reason: "layout changes are not retried"}
+	case attempt >= p.MaxAttempts:
+		return RetryDecision{Reason: "no attempts left"}
+	}
+
+	if !p.takeBudget(host) {
+		return RetryDecision{Reason: "retry budget exhausted for " + host}
+	}
+
+	return RetryDecision{
+		Retry:  true,
+		Delay:  p.backoff(attempt),
+		Reason: "transient error",
+	}
+}
+
+// backoff returns the exponential delay after the given attempt, with equal jitter
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// takeBudget records a retry against host, reporting false if its budget is exhausted
+func (p *RetryPolicy) takeBudget(host string) bool {
+	if p.HostBudget <= 0 {
+		return true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.retries == nil {
+		p.retries = make(map[string][]time.Time)
+	}
+
+	// Forget the retries that left the window
+	now := time.Now()
+	recent := p.retries[host][:0]
+	for _, t := range p.retries[host] {
+		if now.Sub(t) < p.BudgetWindow {
+			recent = append(recent, t)
+		}
+	}
+
+	if len(recent) >= p.HostBudget {
+		p.retries[host] = recent
+		return false
+	}
+	p.retries[host] = append(recent, now)
+	return true
+}
+
+// envInt reads an integer environment variable, falling back to def when unset or invalid
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		DebugLog("Invalid %s %q, using %d", name, value, def)
+		return def
+	}
+	return n
+}
+
+// envDuration reads a duration environment variable (e.g. "2s"), falling back to def when unset or invalid
+func envDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		DebugLog("Invalid %s %q, using %s", name, value, def)
+		return def
+	}
+	return d
+}
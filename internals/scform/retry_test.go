@@ -0,0 +1,99 @@
+package scform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDecide(t *testing.T) {
+	transient := stepError(StepConnect, ErrUpstreamDown, errors.New("connection refused"))
+
+	tests := []struct {
+		name    string
+		attempt int
+		err     error
+		retry   bool
+	}{
+		{"success", 1, nil, false},
+		{"cancelled", 1, fmt.Errorf("wrapped: %w", context.Canceled), false},
+		{"invalid credentials", 1, stepError(StepLogin, ErrInvalidCredentials, nil), false},
+		{"layout changed", 1, stepErrorf(StepExtract, ErrLayoutChanged, "no course table"), false},
+		{"timeout", 1, stepError(StepNavigate, ErrUpstreamDown, context.DeadlineExceeded), true},
+		{"transient first attempt", 1, transient, true},
+		{"transient second attempt", 2, transient, true},
+		{"last attempt", 3, transient, false},
+		{"past the last attempt", 4, transient, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := DefaultRetryPolicy()
+			d := p.Decide("scform.example", tt.attempt, tt.err)
+			if d.Retry != tt.retry {
+				t.Fatalf("Retry = %v (%s), want %v", d.Retry, d.Reason, tt.retry)
+			}
+			if d.Reason == "" {
+				t.Error("empty reason")
+			}
+			if !d.Retry && d.Delay != 0 {
+				t.Errorf("Delay = %s without retry", d.Delay)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyHostBudget(t *testing.T) {
+	p := DefaultRetryPolicy()
+	p.HostBudget = 2
+	err := stepError(StepConnect, ErrUpstreamDown, errors.New("connection refused"))
+
+	for i := 0; i < 2; i++ {
+		if d := p.Decide("a.example", 1, err); !d.Retry {
+			t.Fatalf("retry %d refused: %s", i+1, d.Reason)
+		}
+	}
+	if d := p.Decide("a.example", 1, err); d.Retry {
+		t.Error("retry allowed past the host budget")
+	}
+	if d := p.Decide("b.example", 1, err); !d.Retry {
+		t.Errorf("budget shared across hosts: %s", d.Reason)
+	}
+
+	// Retries that left the window no longer count
+	p.BudgetWindow = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if d := p.Decide("a.example", 1, err); !d.Retry {
+		t.Errorf("retry refused after the window: %s", d.Reason)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := &RetryPolicy{BaseDelay: 2 * time.Second, MaxDelay: 10 * time.Second}
+
+	tests := []struct {
+		attempt int
+		max     time.Duration // Delay before jitter
+	}{
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+		{4, 10 * time.Second},
+		{10, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 50; i++ {
+			delay := p.backoff(tt.attempt)
+			if delay < tt.max/2 || delay > tt.max {
+				t.Fatalf("attempt %d: delay %s outside [%s, %s]", tt.attempt, delay, tt.max/2, tt.max)
+			}
+		}
+	}
+
+	if delay := (&RetryPolicy{}).backoff(1); delay != 0 {
+		t.Errorf("zero base delay gave %s", delay)
+	}
+}
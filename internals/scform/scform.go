@@ -2,30 +2,85 @@ package scform
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"math/rand"
+	"net/url"
 	"os"
-	"regexp"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/go-rod/rod"
 	"github.com/go-rod/rod/lib/launcher"
+	"github.com/go-rod/rod/lib/proto"
+	"golang.org/x/net/html"
 )
 
 var debugEnabled bool
 
+// gradeScale is the scale grades are brought to before being averaged
+var gradeScale float64 = 20
+
+// zeroStatuses are the statuses of grades counted as 0 in averages rather than left out
+var zeroStatuses = map[GradeStatus]bool{}
+
+// GradeStatus tells whether a grade has a value, and why not
+type GradeStatus string
+
+const (
+	GradeGraded    GradeStatus = "graded"     // The grade has a value, 0 included
+	GradeAbsent    GradeStatus = "absent"     // The student missed the evaluation (Abs)
+	GradeExempt    GradeStatus = "exempt"     // The student was exempted from it (Disp)
+	GradeNotGraded GradeStatus = "not_graded" // The evaluation is not graded (NN) or the mark is not a number
+	GradePending   GradeStatus = "pending"    // The grade is not published yet
+)
+
+// gradeStatuses lists the statuses, in the order the layout patterns are tried
+var gradeStatuses = []GradeStatus{GradeAbsent, GradeExempt, GradeNotGraded, GradePending, GradeGraded}
+
+// Label returns the English name of the status, as written in exports
+func (s GradeStatus) Label() string {
+	switch s {
+	case GradeGraded:
+		return "Graded"
+	case GradeAbsent:
+		return "Absent"
+	case GradeExempt:
+		return "Exempt"
+	case GradeNotGraded:
+		return "Not graded"
+	case GradePending:
+		return "Pending"
+	}
+	return string(s)
+}
+
 // Grade represents a single grade entry
 type Grade struct {
-	Value       float64   // The numerical grade value
-	OutOf       float64   // The maximum possible grade (usually 20)
-	Coefficient float64   // Grade coefficient
-	Title       string    // Title/name of the grade
-	Date        time.Time // Date of the grade
-	Type        string    // Type of grade (exam, homework, etc.)
-	Remarks     string    // Any remarks about the grade
-	Observation string    // Any observations about the grade
+	Value       float64     // The numerical grade value
+	OutOf       float64     // The maximum possible grade, as shown by SCForm (usually 20)
+	Coefficient float64     // Grade coefficient
+	Title       string      // Title/name of the grade
+	Date        time.Time   // Date of the grade
+	Type        string      // Type of grade (exam, homework, etc.)
+	Remarks     string      // Any remarks about the grade
+	Observation string      // Any observations about the grade
+	Status      GradeStatus // Whether the grade has a value, Value is 0 unless graded
+}
+
+// Normalized returns the value of the grade brought to the grade scale. A grade without
+// a maximum, like one from an old export, is taken as already on the scale.
+func (g Grade) Normalized() float64 {
+	if g.OutOf <= 0 {
+		return g.Value
+	}
+	return g.Value / g.OutOf * gradeScale
+}
+
+// GradeScale returns the scale averages are computed on, 20 unless SCFORM_GRADE_SCALE sets another one
+func GradeScale() float64 {
+	return gradeScale
 }
 
 // Course represents a course/subject with its grades
@@ -37,47 +92,204 @@ type Course struct {
 
 // CalculateAverage calculates the weighted average for the course
 func (c *Course) CalculateAverage() {
-	var totalWeightedGrade float64
-	var totalCoefficient float64
-
-	for _, grade := range c.Grades {
-		if grade.Value > 0 && grade.Coefficient > 0 { // Only consider valid grades
-			totalWeightedGrade += grade.Value * grade.Coefficient
-			totalCoefficient += grade.Coefficient
+	// Grades from exports predating the statuses only counted when above 0
+	for i := range c.Grades {
+		if c.Grades[i].Status == "" {
+			c.Grades[i].Status = GradeNotGraded
+			if c.Grades[i].Value > 0 {
+				c.Grades[i].Status = GradeGraded
+			}
 		}
 	}
+	c.Average = weightedAverage([]Course{*c})
+}
 
-	if totalCoefficient > 0 {
-		c.Average = totalWeightedGrade / totalCoefficient
+// Period represents a grading period (semester, trimester or year) with its courses
+type Period struct {
+	Name    string    // Period name, as listed on the grades page
+	Start   time.Time // First day of the period, zero if the page does not tell
+	End     time.Time // Last day of the period, zero if the page does not tell
+	Courses []Course  // Courses graded during the period
+	Average float64   // Weighted average over the period
+}
+
+// CalculateAverage calculates the course averages and the weighted average of the period
+func (p *Period) CalculateAverage() {
+	for i := range p.Courses {
+		p.Courses[i].CalculateAverage()
 	}
+	p.Average = weightedAverage(p.Courses)
+}
+
+// Absence represents an absence or a lateness recorded by SCForm
+type Absence struct {
+	Date      time.Time // Day of the absence
+	Slot      string    // Time slot missed, as shown by SCForm
+	Course    string    // Course missed
+	Late      bool      // A lateness rather than an absence
+	Justified bool      // Whether the absence has been justified
+	Reason    string    // Reason or justification given
+}
+
+// TimetableEntry represents a course session of the student timetable
+type TimetableEntry struct {
+	Start   time.Time // Start of the session
+	End     time.Time // End of the session
+	Course  string    // Course taught
+	Room    string    // Room the session takes place in
+	Teacher string    // Teacher giving the session
+}
+
+// Profile holds the details of the student shown on the SCForm home page
+type Profile struct {
+	FullName   string // First and last name
+	Formation  string // Program or diploma prepared
+	Class      string // Class or promotion
+	Centre     string // Training centre
+	SchoolYear string // School year, like 2024-2025
+}
+
+// Document represents an official document published by SCForm, like a bulletin or an attestation
+type Document struct {
+	ID          string // Reference of the stored file, set once it is stored
+	Name        string // Name shown by SCForm
+	FileName    string // Name of the downloaded file
+	ContentType string // MIME type of the file
+	Size        int    // Size of the file in bytes
+	Data        []byte `json:"-"` // Content of the file, until it is stored
 }
 
 type Student struct {
-	Name         string   // Student name
-	Grades       []Course // List of grades for this student
-	TotalAverage float64  // Overall weighted average
+	Name         string           // Student name, the login when the home page does not show it
+	Profile      Profile          // Details from the home page, empty if they could not be read
+	Grades       []Course         // List of grades for this student, over the whole year when there are periods
+	TotalAverage float64          // Overall weighted average
+	Periods      []Period         // Grading periods, empty if the grades page lists none
+	Absences     []Absence        // Absences and lateness, empty if they could not be read
+	Timetable    []TimetableEntry // Course sessions, empty if they could not be read
+	Documents    []Document       // Official documents, empty if they could not be read
 }
 
-// CalculateTotalAverage calculates the overall weighted average for all courses
+// CalculateTotalAverage calculates the overall weighted average for all courses, and the averages of the periods
 func (s *Student) CalculateTotalAverage() {
-	var totalWeightedGrade float64
-	var totalCoefficient float64
-
 	for i := range s.Grades {
 		s.Grades[i].CalculateAverage() // Calculate average for each course
+	}
+	s.TotalAverage = weightedAverage(s.Grades)
+
+	for i := range s.Periods {
+		s.Periods[i].CalculateAverage()
+	}
+}
+
+// ForPeriod returns a copy of the student limited to the courses of the named period, and to its
+// absences when its dates are known, or nil if the student has no such period
+func (s *Student) ForPeriod(name string) *Student {
+	for _, period := range s.Periods {
+		if period.Name != name {
+			continue
+		}
+
+		absences := s.Absences
+		if !period.Start.IsZero() && !period.End.IsZero() {
+			absences = nil
+			for _, absence := range s.Absences {
+				if !absence.Date.Before(period.Start) && !absence.Date.After(period.End) {
+					absences = append(absences, absence)
+				}
+			}
+		}
+
+		return &Student{
+			Name:         s.Name,
+			Profile:      s.Profile,
+			Grades:       period.Courses,
+			TotalAverage: period.Average,
+			Absences:     absences,
+		}
+	}
+	return nil
+}
 
-		// Calculate total weighted average
-		for _, grade := range s.Grades[i].Grades {
-			if grade.Value > 0 && grade.Coefficient > 0 {
-				totalWeightedGrade += grade.Value * grade.Coefficient
-				totalCoefficient += grade.Coefficient
+// MergePeriods gathers the courses of every period into the courses of the whole year.
+// Courses are matched by name and a grade listed by several periods, like in a yearly
+// period overlapping the semesters, is kept once. Identical grades within one period are
+// distinct grades and are all kept.
+func MergePeriods(periods []Period) []Course {
+	var courses []Course
+	index := make(map[string]int)
+	// How many times each grade was kept so far, by course
+	kept := make(map[string]map[gradeKey]int)
+
+	for _, period := range periods {
+		for _, course := range period.Courses {
+			i, ok := index[course.Name]
+			if !ok {
+				i = len(courses)
+				index[course.Name] = i
+				courses = append(courses, Course{Name: course.Name, Grades: []Grade{}})
+				kept[course.Name] = make(map[gradeKey]int)
+			}
+
+			// A grade is only new when the period lists it more times than the previous ones did
+			seen := make(map[gradeKey]int)
+			for _, grade := range course.Grades {
+				key := keyOf(grade)
+				seen[key]++
+				if seen[key] > kept[course.Name][key] {
+					kept[course.Name][key]++
+					courses[i].Grades = append(courses[i].Grades, grade)
+				}
 			}
 		}
 	}
 
+	return courses
+}
+
+// gradeKey identifies a grade across the periods listing it
+type gradeKey struct {
+	title       string
+	date        int64 // Unix time, time.Time values compare their location too
+	value       float64
+	outOf       float64
+	coefficient float64
+	status      GradeStatus
+}
+
+// keyOf returns the key of a grade
+func keyOf(grade Grade) gradeKey {
+	return gradeKey{
+		title:       grade.Title,
+		date:        grade.Date.Unix(),
+		value:       grade.Value,
+		outOf:       grade.OutOf,
+		coefficient: grade.Coefficient,
+		status:      grade.Status,
+	}
+}
+
+// weightedAverage returns the average of the valid grades of the courses, weighted by their coefficients
+func weightedAverage(courses []Course) float64 {
+	var totalWeightedGrade float64
+	var totalCoefficient float64
+
+	for _, course := range courses {
+		for _, grade := range course.Grades {
+			// A genuine 0 counts, grades without a value only when configured to count as 0
+			if grade.Coefficient <= 0 || (grade.Status != GradeGraded && !zeroStatuses[grade.Status]) {
+				continue
+			}
+			// Grades marked /10 or /100 count like their /20 equivalent
+			totalWeightedGrade += grade.Normalized() * grade.Coefficient
+			totalCoefficient += grade.Coefficient
+		}
+	}
+
 	if totalCoefficient > 0 {
-		s.TotalAverage = totalWeightedGrade / totalCoefficient
+		return totalWeightedGrade / totalCoefficient
 	}
+	return 0
 }
 
 func init() {
@@ -89,6 +301,25 @@ func init() {
 	if debugEnabled {
 		log.Println("Debug mode enabled")
 	}
+
+	for _, name := range strings.Split(os.Getenv("SCFORM_ZERO_STATUSES"), ",") {
+		status := GradeStatus(strings.TrimSpace(name))
+		switch {
+		case status == "":
+		case status == GradeGraded || !slices.Contains(gradeStatuses, status):
+			log.Printf("Ignoring grade status %q in SCFORM_ZERO_STATUSES", status)
+		default:
+			zeroStatuses[status] = true
+		}
+	}
+
+	if value := os.Getenv("SCFORM_GRADE_SCALE"); value != "" {
+		if scale := parseFloat(value); scale > 0 {
+			gradeScale = scale
+		} else {
+			log.Printf("Invalid SCFORM_GRADE_SCALE %q, using %g", value, gradeScale)
+		}
+	}
 }
 
 // DebugLog logs a message only if debug mode is enabled
@@ -98,410 +329,577 @@ func DebugLog(format string, v ...interface{}) {
 	}
 }
 
-// ProgressUpdate represents a progress update message
-type ProgressUpdate struct {
-	Status   string  `json:"status"`
-	Message  string  `json:"message"`
-	Progress float64 `json:"progress"`
+// GetStudentGrades logs into SCForm with a browser and returns the student grades.
+// It is kept for callers that predate GradeSource and uses a RodSource under the hood.
+func GetStudentGrades(scformURL, username, password string, progressChan chan<- ProgressUpdate) (*Student, error) {
+	creds := Credentials{URL: scformURL, Username: username, Password: password}
+	source := &RodSource{}
+	if err := source.sessionFilesFromEnv(); err != nil {
+		return nil, err
+	}
+	return source.Fetch(context.Background(), creds, ChanSink(progressChan))
 }
 
-func GetStudentGrades(scformURL, username, password string, progressChan chan<- ProgressUpdate) (*Student, error) {
+// Fetch logs into SCForm with go-rod, navigates to the grades page and parses it
+func (s *RodSource) Fetch(ctx context.Context, creds Credentials, progress ProgressSink) (_ *Student, err error) {
+	scformURL, username, password := creds.URL, creds.Username, creds.Password
+
+	// before connecting test if password and username are not empty
+	if username == "" || password == "" {
+		return nil, &StepError{Step: StepLogin, Kind: ErrInvalidCredentials, Err: fmt.Errorf("username and password are required")}
+	}
+
+	// Set default timeout for all operations (increased to 5 minutes for complex scraping).
+	// The browser is bound to this context so a caller cancellation aborts any pending step.
+	ctx, cancel := context.WithTimeout(ctx, 300*time.Second)
+	defer cancel()
+
+	// Record the attempt so a diagnostic bundle can be captured if it fails, and time its steps
+	diag := s.Diagnostics.recorder(creds)
+	timer := &stepTimer{username: username}
+	// Progress updates are filled in with the step in progress and the time left
+	tracker := newStepTracker(progress, rodSteps, rodHistory)
+	progress = tracker.sink()
+	step := func(name string) {
+		diag.step(name)
+		timer.step(name)
+		tracker.step(name)
+	}
+	defer func() { timer.log(err) }()
+	step(StepConnect)
+
 	// Send initial progress update
-	if progressChan != nil {
-		progressChan <- ProgressUpdate{
-			Status:   "connecting",
-			Message:  "Connecting to browser...",
-			Progress: 0.1,
-		}
+	progress.Send(ProgressUpdate{
+		Status:  "connecting",
+		Message: "Connecting to browser...",
+	})
+
+	browser, release, err := s.browser(ctx)
+	if err != nil {
+		return nil, diag.fail(nil, err)
 	}
+	defer func() {
+		// Capture the bundle before the browser is released
+		err = diag.fail(browser, err)
+		release(err)
+	}()
 
-	remoteURL := os.Getenv("SCFORM_REMOTE_URL")
-	DebugLog("remoteURL: %s", remoteURL)
+	// Block the requests the retrieval does not need, like images and analytics
+	filter := s.Filter.session(scformURL)
+	defer filter.stop(username)
+
+	// Record or replay the HTTP exchanges, stopped before the browser is released
+	stopSession, err := s.startSession(browser, creds, filter)
+	if err != nil {
+		return nil, stepError(StepConnect, ErrBrowserUnavailable, err)
+	}
+	defer stopSession()
 
-	var browser *rod.Browser
-	var err error
+	// Set a default shorter timeout for all browser operations
+	browser = browser.Timeout(30 * time.Second)
 
-	// Set default timeout for all operations (increased to 5 minutes for complex scraping)
-	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
-	defer cancel()
+	// Profiles are read once so a reload does not change them halfway through the retrieval
+	profiles := Layouts()
 
-	if remoteURL != "" {
-		log.Printf("remoteURL: %s", remoteURL)
-		// Add error handling for remote connection
-		// if strings.HasPrefix(remoteURL, "ws://") || strings.HasPrefix(remoteURL, "wss://") {
-		// 	DebugLog("Connecting to remote browser via WebSocket at: %s", remoteURL)
-		// 	ws := NewWebSocket(remoteURL)
-		// 	if ws == nil {
-		// 		return nil, fmt.Errorf("failed to establish WebSocket connection to %s", remoteURL)
-		// 	}
-		// 	client := cdp.New().Start(ws)
-		// 	browser = rod.New().Client(client).Context(ctx)
-		// 	err = browser.Connect()
-		// 	if err != nil {
-		// 		DebugLog("Failed to connect browser via WebSocket: %v", err)
-		// 		// Make sure to close the WebSocket connection if browser connection fails
-		// 		ws.Close()
-		// 		return nil, fmt.Errorf("failed to connect browser via WebSocket: %v", err)
-		// 	} else {
-		// 		DebugLog("Successfully connected browser via WebSocket")
-		// 	}
-		// } else {
-		// 	DebugLog("Connecting to remote browser via direct URL: %s", remoteURL)
-		// 	browser = rod.New().ControlURL(remoteURL).Context(ctx).MustConnect()
-		// }
-		// if err != nil {
-		// 	return nil, fmt.Errorf("failed to connect to remote browser: %v", err)
-		// }
-		// remote url can have two url in one string separated by a comma please split and get one at random if no comma, use the url as is
-		if !strings.Contains(remoteURL, ",") {
-			DebugLog("Connecting to remote browser via direct URL: %s", remoteURL)
-			browser = rod.New().ControlURL(remoteURL).Context(ctx).MustConnect()
+	step(StepOpenLogin)
+
+	// The page is opened blank so its requests are filtered from the first one
+	page, err := browser.Page(proto.TargetCreateTarget{})
+	if err != nil {
+		return nil, stepError(StepOpenLogin, ErrBrowserUnavailable, err)
+	}
+	diag.watch(ctx, page)
+	if err := filter.watch(ctx, page); err != nil {
+		return nil, stepError(StepOpenLogin, ErrBrowserUnavailable, err)
+	}
+
+	// A login saved by a previous retrieval of the user goes straight to the grades page
+	var profile *LayoutProfile
+	var homeURL string
+	var studentProfile Profile
+	resumed := false
+	saved := s.loadLogin(creds)
+	if saved != nil {
+		step(StepNavigate)
+		progress.Send(ProgressUpdate{
+			Status:  "resuming_session",
+			Message: "Resuming previous session...",
+		})
+
+		profile, resumed = resumeLogin(browser, page, saved, profiles)
+		if resumed {
+			DebugLog("Resumed the saved login of %s", username)
+			homeURL, studentProfile = saved.HomeURL, saved.Profile
 		} else {
-			urls := strings.Split(remoteURL, ",")
-			randomURL := urls[rand.Intn(len(urls))]
-			randomURL = strings.TrimSpace(randomURL)
-			DebugLog("Connecting to remote browser via direct URL: %s", randomURL)
-			browser = rod.New().ControlURL(randomURL).Context(ctx).MustConnect()
-			browser.MustIgnoreCertErrors(true)
-			browser = browser.NoDefaultDevice()
+			DebugLog("Saved login of %s expired, logging in again", username)
+			s.forgetLogin(creds)
+		}
+	}
+
+	// logIn goes through the login page to the grades page, saving the login for the next retrieval
+	logIn := func() error {
+		if saved != nil {
+			step(StepOpenLogin)
 		}
-	} else {
-		// use already installed chrome browser
-		chromePath := os.Getenv("CHROME_PATH")
 
-		// Use headless by default, only use headed mode for debugging
-		useHeadless := os.Getenv("SCFORM_HEADLESS") != "false"
+		// Send progress update
+		progress.Send(ProgressUpdate{
+			Status:  "navigating",
+			Message: "Navigating to login page...",
+		})
+
+		// The home tab SC-Connect opens is filtered from its next navigation on, and the images of
+		// a challenge are let through so the user can see it
+		var err error
+		page, profile, err = login(ctx, browser, page, creds, profiles, progress, step, func(tab *rod.Page) error {
+			diag.watch(ctx, tab)
+			return filter.watch(ctx, tab)
+		}, filter.allowImages)
+		if err != nil {
+			return err
+		}
 
-		var l *launcher.Launcher
-		if chromePath == "" {
-			path, _ := launcher.LookPath()
-			l = launcher.New().Bin(path).Headless(useHeadless)
-		} else {
-			l = launcher.New().Bin(chromePath).Headless(useHeadless)
+		// Remember the home page, the other pages of the profile are relative to it
+		homeInfo, err := page.Info()
+		if err != nil {
+			return stepError(StepLogin, ErrBrowserUnavailable, err)
+		}
+		homeURL = homeInfo.URL
+
+		// The home page shows who is logged in, the grades can still be read without it
+		if studentProfile, err = readProfile(page, profile); err != nil {
+			DebugLog("Failed to read the student profile: %v", err)
 		}
 
-		// Set browser flags for better performance
-		l = l.Set("disable-gpu", "true").
-			Set("disable-dev-shm-usage", "true").
-			Set("disable-web-security", "true").
-			Set("disable-features", "IsolateOrigins,site-per-process").
-			Set("disable-site-isolation-trials", "true").
-			Set("disable-blink-features", "AutomationControlled").
-			Set("blink-settings", "imagesEnabled=false")
+		// The next retrieval of the user can skip the login with its cookies
+		s.saveLogin(browser, creds, &savedLogin{HomeURL: homeURL, Layout: profile.Name, Profile: studentProfile})
+
+		step(StepNavigate)
 
-		// Launch and connect to the browser
-		url := l.MustLaunch()
-		browser = rod.New().ControlURL(url).Context(ctx).MustConnect().NoDefaultDevice()
+		// Send progress update
+		progress.Send(ProgressUpdate{
+			Status:  "navigating_grades",
+			Message: "Navigating to grades page...",
+		})
+
+		return openGradesPage(page, profile)
 	}
 
-	// Set a default shorter timeout for all browser operations
-	browser = browser.Timeout(30 * time.Second)
+	if !resumed {
+		if err := logIn(); err != nil {
+			return nil, err
+		}
+	}
 
-	// Send progress update
-	if progressChan != nil {
-		progressChan <- ProgressUpdate{
-			Status:   "navigating",
-			Message:  "Navigating to login page...",
-			Progress: 0.2,
+	courses, periods, layout, err := readGrades(ctx, page, profile, profiles, progress, step)
+	if err != nil && resumed && ctx.Err() == nil {
+		// The saved login is not trusted again once a retrieval failed with it
+		log.Printf("Retrieval with the saved login of %s failed, logging in again: %v", userKey(username), err)
+		s.forgetLogin(creds)
+		if err := browser.SetCookies(nil); err != nil {
+			DebugLog("Failed to clear restored cookies: %v", err)
 		}
+		if err := logIn(); err != nil {
+			return nil, err
+		}
+		courses, periods, layout, err = readGrades(ctx, page, profile, profiles, progress, step)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// Add error handling for browser operations
-	defer func() {
-		if browser != nil {
-			browser.Close()
+	step(StepAbsences)
+
+	progress.Send(ProgressUpdate{
+		Status:  "fetching_absences",
+		Message: "Fetching absences...",
+	})
+
+	// The grades are returned without the absences if their page cannot be read
+	absences, err := fetchAbsences(page, homeURL, layout)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
-	}()
+		log.Printf("Failed to fetch absences for %s: %v", username, err)
+	}
 
-	// Set a shorter timeout for page navigation (15 seconds)
-	page := browser.Timeout(15 * time.Second).MustPage(scformURL)
+	step(StepTimetable)
 
-	// Wait for the page to load and Angular to initialize
-	page.Timeout(10 * time.Second).MustWaitStable()
+	progress.Send(ProgressUpdate{
+		Status:  "fetching_timetable",
+		Message: "Fetching timetable...",
+	})
 
-	// Use more targeted wait with a timeout instead of waiting for DOM stable
-	// Wait up to 10 seconds for the email input field (new Angular structure)
-	emailInput := page.Timeout(10 * time.Second).MustElement("input[id='email']")
-	emailInput.MustInput(username)
+	// Likewise for the timetable
+	timetable, err := fetchTimetable(page, homeURL, layout)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Printf("Failed to fetch timetable for %s: %v", username, err)
+	}
 
-	// Wait up to 5 seconds for the password input field (new Angular structure)
-	passwordInput := page.Timeout(5 * time.Second).MustElement("input[id='password']")
-	passwordInput.MustInput(password)
+	step(StepDocuments)
 
-	// Send progress update
-	if progressChan != nil {
-		progressChan <- ProgressUpdate{
-			Status:   "logging_in",
-			Message:  "Logging in...",
-			Progress: 0.3,
+	progress.Send(ProgressUpdate{
+		Status:  "fetching_documents",
+		Message: "Fetching documents...",
+	})
+
+	// Likewise for the official documents
+	documents, err := fetchDocuments(page, homeURL, layout)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
+		log.Printf("Failed to fetch documents for %s: %v", username, err)
 	}
 
-	// Click the login button (new Angular structure)
-	// before clinking test if password and username are not empty
-	if username == "" || password == "" {
-		return nil, fmt.Errorf("username and password are required")
-	} else {
-		loginButton := page.Timeout(5 * time.Second).MustElement("button[type='submit']")
-		loginButton.MustClick()
-		page.MustWaitStable()
+	// Send progress update
+	progress.Send(ProgressUpdate{
+		Status:  "calculating",
+		Message: "Calculating averages...",
+	})
+
+	// Create a student and calculate averages, the whole year gathering the periods when there are
+	student := &Student{
+		Name:      username,
+		Profile:   studentProfile,
+		Grades:    courses,
+		Periods:   periods,
+		Absences:  absences,
+		Timetable: timetable,
+		Documents: documents,
+	}
+	if studentProfile.FullName != "" {
+		student.Name = studentProfile.FullName
 	}
+	if len(periods) > 0 {
+		student.Grades = MergePeriods(periods)
+	}
+	student.CalculateTotalAverage()
+	tracker.finish()
+
+	// Send completion progress update
+	progress.Send(ProgressUpdate{
+		Status:  "complete",
+		Message: "Done!",
+	})
 
-	// list open tabs
-	tabs := browser.MustPages()
-	log.Printf("Number of open tabs: %d", len(tabs))
+	return student, nil
+}
+
+// readGrades reads the courses of the grades page and of its other grading periods, returning the
+// layout profile their tables matched
+func readGrades(ctx context.Context, page *rod.Page, profile *LayoutProfile, profiles []*LayoutProfile, progress ProgressSink, step func(string)) ([]Course, []Period, *LayoutProfile, error) {
+	step(StepDisplayMode)
+
+	// Switch the display mode with the first radio button of the profile found on the page
+	for i, selector := range profile.Grades.DisplayMode {
+		// The preferred radio button gets more time to show up than the fallbacks
+		wait := 2 * time.Second
+		if i == 0 {
+			wait = 5 * time.Second
+		}
 
-	for _, tab := range tabs {
-		log.Printf("Tab: %s", tab.MustInfo().URL)
-		// if tab url contain Stagiaire.aspx, switch to it
-		if strings.Contains(tab.MustInfo().URL, "Stagiaire.aspx") {
-			page = tab
-			break
+		radio, err := page.Timeout(wait).Element(selector)
+		if err != nil {
+			DebugLog("Display mode radio button %s not found", selector)
+			continue
+		}
+		if err := radio.Click(proto.InputMouseButtonLeft, 1); err != nil {
+			DebugLog("Failed to click %s: %v", selector, err)
+			continue
 		}
+		if err := page.Timeout(5 * time.Second).WaitStable(time.Second); err != nil {
+			return nil, nil, nil, stepError(StepDisplayMode, ErrUpstreamDown, err)
+		}
+		break
 	}
 
-	// Wait for navigation after login
-	page.Timeout(10 * time.Second).MustWaitStable()
+	step(StepExtract)
 
 	// Send progress update
-	if progressChan != nil {
-		progressChan <- ProgressUpdate{
-			Status:   "navigating_grades",
-			Message:  "Navigating to grades page...",
-			Progress: 0.4,
-		}
+	progress.Send(ProgressUpdate{
+		Status:  "fetching_grades",
+		Message: "Fetching grades...",
+	})
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Wait for the course tables of any profile, starting with the one of the login page
+	profiles = preferLayout(profiles, profile)
+	if _, _, err := raceLayouts(page.Timeout(10*time.Second), profiles, func(p *LayoutProfile) string { return p.Grades.CourseTable }); err != nil {
+		return nil, nil, nil, stepError(StepExtract, ErrLayoutChanged, fmt.Errorf("failed to find course tables: %w", err))
+	}
+
+	// The page is read once and parsed here rather than with an element lookup per field
+	raw, options, layout, err := extractGrades(page, profiles)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
+	// Send progress update
+	progress.Send(ProgressUpdate{
+		Status:  "processing_course",
+		Message: "Processing courses...",
+	})
+
+	if layout == nil {
+		layout = profile
+	}
+	courses := buildCourses(raw, layout)
+
+	// Walk the other grading periods listed on the page, if any
+	periods, err := fetchPeriods(ctx, page, options, courses, layout, progress)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return courses, periods, layout, nil
+}
+
+// openGradesPage goes from the home page to the grades page, through the GoTo function of
+// SC-Connect or else a navigation link of the profile
+func openGradesPage(page *rod.Page, profile *LayoutProfile) error {
 	// Wait for post-login page to load, then try to navigate to grades
 	// First, try to find if we're already on a dashboard or need to navigate
-	page.Timeout(5 * time.Second).MustWaitStable()
+	if err := page.Timeout(5 * time.Second).WaitStable(time.Second); err != nil {
+		return stepError(StepNavigate, ErrUpstreamDown, err)
+	}
 
 	// Try to navigate to grades page - this might be different in the new interface
 	// We'll first try the old approach, but with error handling
-	_, err = page.Eval(`() => {
+	_, err := page.Eval(`(gradesPage) => {
 		console.log('Navigating to grades page...');
 		if (typeof GoTo === 'function') {
-			GoTo('Eleve/MesNotes.aspx');
+			GoTo(gradesPage);
 		} else {
 			console.log('GoTo function not found');
 		}
-	}`)
+	}`, profile.Grades.Page)
 
 	if err != nil {
 		DebugLog("Failed to navigate using JavaScript: %v", err)
 		// Try to find a grades navigation link manually
 		var gradesLink *rod.Element
-		// Try various selectors for grades navigation
-		selectors := []string{
-			"a[href*='notes']",
-			"a[href*='grades']",
-			"[routerlink*='notes']",
-			"[routerlink*='grades']",
-			"a:contains('Notes')",
-			"a:contains('Grades')",
-			"button:contains('Notes')",
-			"button:contains('Grades')",
-		}
-
-		for _, selector := range selectors {
+		// Try the grades navigation selectors of the profile
+		for _, selector := range profile.Grades.NavigationLinks {
 			gradesLink, err = page.Timeout(2 * time.Second).Element(selector)
 			if err == nil {
 				DebugLog("Found grades link with selector: %s", selector)
-				gradesLink.MustClick()
+				err = gradesLink.Click(proto.InputMouseButtonLeft, 1)
 				break
 			}
 		}
 
 		if err != nil {
-			return nil, fmt.Errorf("failed to navigate to grades page: could not find navigation elements")
+			return stepErrorf(StepNavigate, ErrLayoutChanged, "could not find navigation elements")
 		}
 	}
 
 	// Use a shorter wait timeout of 5 seconds
-	page.Timeout(5 * time.Second).MustWaitStable()
-
-	// Try to find the radio button for grade display (this might also be different)
-	// Use error handling since the interface might have changed
-	radioButton, err := page.Timeout(5 * time.Second).Element("input[id='MainContent_RadioButtonAffichage_1']")
-	if err == nil {
-		radioButton.MustClick()
-		page.Timeout(5 * time.Second).MustWaitStable()
-	} else {
-		DebugLog("Radio button not found, trying alternative selectors")
-		// Try alternative selectors for the display mode
-		altSelectors := []string{
-			"input[type='radio'][value='1']",
-			"input[name*='affichage']",
-			"input[name*='display']",
-		}
-
-		for _, selector := range altSelectors {
-			if altRadio, altErr := page.Timeout(2 * time.Second).Element(selector); altErr == nil {
-				altRadio.MustClick()
-				page.Timeout(3 * time.Second).MustWaitStable()
-				break
-			}
-		}
+	if err := page.Timeout(5 * time.Second).WaitStable(time.Second); err != nil {
+		return stepError(StepNavigate, ErrUpstreamDown, err)
 	}
 
-	// Send progress update
-	if progressChan != nil {
-		progressChan <- ProgressUpdate{
-			Status:   "fetching_grades",
-			Message:  "Fetching grades...",
-			Progress: 0.5,
-		}
-	}
+	return nil
+}
 
-	// Get all course tables with a timeout of 10 seconds
-	courseTables, err := page.Timeout(10 * time.Second).Elements("table.AfficheInfoEnMieux")
-	if err != nil {
-		return nil, fmt.Errorf("failed to find course tables: %v", err)
+// fetchPeriods selects each grading period listed on the grades page in turn and extracts its
+// courses, courses being those of the period shown. It returns no period if none is listed.
+func fetchPeriods(ctx context.Context, page *rod.Page, options []periodOption, courses []Course, profile *LayoutProfile, progress ProgressSink) ([]Period, error) {
+	if len(options) == 0 {
+		return nil, nil
 	}
 
-	var courses []Course
-	totalTables := len(courseTables)
-
-	for i, table := range courseTables {
-		// Send progress update for each course
-		if progressChan != nil {
-			progress := 0.5 + (float64(i) / float64(totalTables) * 0.4)
-			progressChan <- ProgressUpdate{
-				Status:   "processing_course",
-				Message:  fmt.Sprintf("Processing course %d of %d...", i+1, totalTables),
-				Progress: progress,
-			}
-		}
-
-		// Extract course name with a timeout of 5 seconds
-		nameElement, err := table.Timeout(5 * time.Second).Element("span[id*='NomCompletLabel']")
-		if err != nil {
-			DebugLog("Failed to find course name element, skipping table")
+	periods := make([]Period, 0, len(options))
+	for i, option := range options {
+		if option.Selected {
+			periods = append(periods, newPeriod(option, courses, profile))
 			continue
 		}
-
-		courseName, err := nameElement.Text()
-		if err != nil {
-			DebugLog("Failed to get course name text, skipping table")
-			continue
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
-		courseName = strings.TrimSpace(courseName)
 
-		// Create new course
-		course := Course{
-			Name:   courseName,
-			Grades: []Grade{},
-		}
+		progress.Send(ProgressUpdate{
+			Status:  "fetching_period",
+			Message: fmt.Sprintf("Fetching period %s (%d/%d)...", strings.TrimSpace(option.Name), i+1, len(options)),
+		})
 
-		// Find all grade divs within the table with a timeout of 5 seconds
-		gradeDivs, err := table.Timeout(5 * time.Second).Elements("div[id='DivNOTE']")
+		raw, err := selectPeriod(page, profile, option)
 		if err != nil {
-			DebugLog("Failed to find grade divs for course %s: %v", courseName, err)
-			continue
+			return nil, stepError(StepExtract, ErrUpstreamDown, fmt.Errorf("failed to select period %s: %w", option.Name, err))
 		}
+		periods = append(periods, newPeriod(option, buildCourses(raw, profile), profile))
+	}
 
-		for _, gradeDiv := range gradeDivs {
-			grade := Grade{}
-
-			// Extract grade value and maximum with a timeout of 2 seconds
-			if valueSpan, err := gradeDiv.Timeout(2 * time.Second).Element("span[id*='Label1']"); err == nil {
-				if value, err := valueSpan.Text(); err == nil {
-					value = strings.TrimSpace(value)
-					if value != "" {
-						grade.Value = parseFloat(value)
-						grade.OutOf = 20 // Default out of 20
-					}
-				}
-			}
+	return periods, nil
+}
 
-			// Extract coefficient with a timeout of 2 seconds
-			if coeffSpan, err := gradeDiv.Timeout(2 * time.Second).Element("span[id*='Label3']"); err == nil {
-				if coeffText, err := coeffSpan.Text(); err == nil {
-					coeffText = strings.TrimSpace(coeffText)
-					coeffText = strings.TrimPrefix(coeffText, "coeff. ")
-					grade.Coefficient = parseFloat(coeffText)
-				}
-			}
+// selectPeriod picks a period in the drop-down of the grades page and extracts the courses of the page it posts back to
+func selectPeriod(page *rod.Page, profile *LayoutProfile, option periodOption) ([]rawCourse, error) {
+	periodSelect, err := page.Timeout(5 * time.Second).Element(profile.Grades.PeriodSelect)
+	if err != nil {
+		return nil, err
+	}
 
-			// Extract title and date with a timeout of 2 seconds
-			if titleSpan, err := gradeDiv.Timeout(2 * time.Second).Element("span[id*='Label7']"); err == nil {
-				if titleText, err := titleSpan.Text(); err == nil {
-					titleText = strings.TrimSpace(titleText)
-					// Use regex to match the pattern: any text followed by "du" and a date
-					re := regexp.MustCompile(`(.*?)\s+du\s+(\d{2}/\d{2}/\d{4})`)
-					matches := re.FindStringSubmatch(titleText)
-					if len(matches) == 3 {
-						grade.Title = strings.TrimSpace(matches[1])
-						dateStr := strings.TrimSpace(matches[2])
-						grade.Date = parseDate(dateStr)
-					} else {
-						// If no date pattern found, use the entire text as title
-						grade.Title = titleText
-					}
-				}
-			}
+	// The drop-down posts the form back when it changes, reloading the page. The current drop-down
+	// is marked so the reloaded one can be told apart, WaitNavigation reporting no failure.
+	wait := page.Timeout(15 * time.Second).WaitNavigation(proto.PageLifecycleEventNameLoad)
+	if _, err := periodSelect.Eval(`(value) => {
+		this.dataset.scformStale = 'true';
+		this.value = value;
+		this.dispatchEvent(new Event('change', { bubbles: true }));
+	}`, option.Value); err != nil {
+		return nil, err
+	}
+	wait()
 
-			// Extract type with a timeout of 2 seconds
-			if typeSpan, err := gradeDiv.Timeout(2 * time.Second).Element("span[id*='Label8']"); err == nil {
-				if typeText, err := typeSpan.Text(); err == nil {
-					grade.Type = strings.TrimSpace(typeText)
-				}
-			}
+	if err := page.Timeout(10 * time.Second).Wait(rod.Eval(`(selector, value) => {
+		const select = document.querySelector(selector);
+		return !!select && !select.dataset.scformStale && select.value === value;
+	}`, profile.Grades.PeriodSelect, option.Value)); err != nil {
+		return nil, fmt.Errorf("page did not post back with the period selected: %w", err)
+	}
+	if err := page.Timeout(5 * time.Second).WaitStable(time.Second); err != nil {
+		return nil, err
+	}
 
-			// Extract remarks if present with a timeout of 2 seconds
-			if remarksSpan, err := gradeDiv.Timeout(2 * time.Second).Element("span[id*='Label9']"); err == nil {
-				if remarks, err := remarksSpan.Text(); err == nil {
-					grade.Remarks = strings.TrimSpace(remarks)
-					grade.Remarks = strings.TrimPrefix(grade.Remarks, "Remarque : ")
-				}
-			}
+	raw, _, _, err := extractGrades(page, []*LayoutProfile{profile})
+	return raw, err
+}
 
-			// Extract observations if present with a timeout of 2 seconds
-			if obsSpan, err := gradeDiv.Timeout(2 * time.Second).Element("span[id*='Label10']"); err == nil {
-				if obs, err := obsSpan.Text(); err == nil {
-					grade.Observation = strings.TrimSpace(obs)
-					grade.Observation = strings.TrimPrefix(grade.Observation, "Observation : ")
-				}
-			}
+// openProfilePage navigates to a page of the layout profile, relative to the home page, and parses it.
+// Failures are reported for the given step.
+func openProfilePage(page *rod.Page, homeURL, path, step string) (*html.Node, error) {
+	home, err := url.Parse(homeURL)
+	if err != nil {
+		return nil, stepError(step, ErrLayoutChanged, fmt.Errorf("invalid home page URL %q: %v", homeURL, err))
+	}
+	pageURL := home.ResolveReference(&url.URL{Path: path}).String()
 
-			// Only append grade if we have at least some basic information
-			if grade.Value > 0 || grade.Title != "" {
-				course.Grades = append(course.Grades, grade)
-			}
-		}
+	if err := page.Timeout(15 * time.Second).Navigate(pageURL); err != nil {
+		return nil, stepError(step, ErrUpstreamDown, err)
+	}
+	if err := page.Timeout(10 * time.Second).WaitStable(time.Second); err != nil {
+		return nil, stepError(step, ErrUpstreamDown, err)
+	}
 
-		// Only append course if it has a name and at least one grade
-		if course.Name != "" && len(course.Grades) > 0 {
-			courses = append(courses, course)
-		}
+	pageHTML, err := page.HTML()
+	if err != nil {
+		return nil, stepError(step, ErrBrowserUnavailable, err)
+	}
+	doc, err := html.Parse(strings.NewReader(pageHTML))
+	if err != nil {
+		return nil, stepError(step, ErrLayoutChanged, err)
 	}
+	return doc, nil
+}
 
-	// Send progress update
-	if progressChan != nil {
-		progressChan <- ProgressUpdate{
-			Status:   "calculating",
-			Message:  "Calculating averages...",
-			Progress: 0.9,
+// browser returns an isolated browser for one retrieval, from the pool if there is one.
+// The release function must be called with the error the retrieval ended with.
+func (s *RodSource) browser(ctx context.Context) (*rod.Browser, func(error), error) {
+	// Hijacking is browser-wide, it would catch the requests of other pooled retrievals
+	if s.Pool != nil && s.RecordDir == "" && s.ReplayPath == "" {
+		lease, err := s.Pool.Acquire(ctx)
+		if err != nil {
+			return nil, nil, err
 		}
+		return lease.Browser, lease.Release, nil
 	}
 
-	// Create a student and calculate averages
-	student := &Student{
-		Name:   username,
-		Grades: courses,
+	browser, err := connectBrowser(ctx)
+	if err != nil {
+		return nil, nil, err
 	}
-	student.CalculateTotalAverage()
 
-	// Send completion progress update
-	if progressChan != nil {
-		progressChan <- ProgressUpdate{
-			Status:   "complete",
-			Message:  "Done!",
-			Progress: 1.0,
+	return browser, func(err error) {
+		disconnectBrowser(browser, err)
+	}, nil
+}
+
+// disconnectBrowser closes a browser started by connectBrowser. The error the browser
+// failed with, if any, is reported to the remote registry so its endpoint is left out.
+func disconnectBrowser(browser *rod.Browser, err error) {
+	// The browser is closed with a fresh context so it still happens after a cancellation
+	if closeErr := browser.Context(context.Background()).Timeout(5 * time.Second).Close(); closeErr != nil {
+		DebugLog("Failed to close browser: %v", closeErr)
+	}
+
+	if remote := RemoteBrowsers(); remote != nil {
+		if !errors.Is(err, ErrBrowserUnavailable) {
+			err = nil
 		}
+		remote.Release(browser, err)
+	}
+}
+
+// raceLayouts waits for the first profile whose selector, picked by selector, shows up on the page.
+// Profiles sharing a selector are matched in order.
+func raceLayouts(page *rod.Page, profiles []*LayoutProfile, selector func(*LayoutProfile) string) (*rod.Element, *LayoutProfile, error) {
+	var matched *LayoutProfile
+	race := page.Race()
+	for _, profile := range profiles {
+		race = race.Element(selector(profile)).Handle(func(*rod.Element) error {
+			matched = profile
+			return nil
+		})
 	}
 
-	return student, nil
+	el, err := race.Do()
+	if err != nil {
+		return nil, nil, err
+	}
+	return el, matched, nil
+}
+
+// connectBrowser connects to one of the remote browsers set in SCFORM_REMOTE_URL, or launches a local one
+func connectBrowser(ctx context.Context) (*rod.Browser, error) {
+	// Remote endpoints are health-checked and failed over by the registry
+	if remote := RemoteBrowsers(); remote != nil {
+		return remote.Connect(ctx)
+	}
+
+	// use already installed chrome browser
+	chromePath := os.Getenv("CHROME_PATH")
+
+	// Use headless by default, only use headed mode for debugging
+	useHeadless := os.Getenv("SCFORM_HEADLESS") != "false"
+
+	var l *launcher.Launcher
+	if chromePath == "" {
+		path, _ := launcher.LookPath()
+		l = launcher.New().Bin(path).Headless(useHeadless)
+	} else {
+		l = launcher.New().Bin(chromePath).Headless(useHeadless)
+	}
+
+	// Set browser flags for better performance
+	l = l.Set("disable-gpu", "true").
+		Set("disable-dev-shm-usage", "true").
+		Set("disable-web-security", "true").
+		Set("disable-features", "IsolateOrigins,site-per-process").
+		Set("disable-site-isolation-trials", "true").
+		Set("disable-blink-features", "AutomationControlled")
+
+	// Launch and connect to the browser, giving up if the caller cancels
+	url, err := l.Context(ctx).Launch()
+	if err != nil {
+		l.Kill()
+		return nil, stepError(StepConnect, ErrBrowserUnavailable, err)
+	}
+
+	browser := rod.New().ControlURL(url).Context(ctx)
+	if err := browser.Connect(); err != nil {
+		l.Kill()
+		return nil, stepError(StepConnect, ErrBrowserUnavailable, err)
+	}
+	return browser.NoDefaultDevice(), nil
 }
 
 // Helper function to parse float values
@@ -0,0 +1,218 @@
+package scform
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMergePeriods(t *testing.T) {
+	ds1 := Grade{Value: 14, OutOf: 20, Coefficient: 1, Title: "DS", Date: date(2024, 10, 1), Status: GradeGraded}
+	ds2 := Grade{Value: 12, OutOf: 20, Coefficient: 1, Title: "DS", Date: date(2025, 3, 1), Status: GradeGraded}
+	oral := Grade{Value: 16, OutOf: 20, Coefficient: 1, Title: "Oral", Date: date(2024, 11, 5), Status: GradeGraded}
+	tp := Grade{Value: 15, OutOf: 20, Coefficient: 1, Title: "TP", Date: date(2024, 12, 3), Status: GradeGraded}
+
+	tests := []struct {
+		name    string
+		periods []Period
+		want    []Course
+	}{
+		{
+			name: "disjoint semesters",
+			periods: []Period{
+				{Name: "S1", Courses: []Course{{Name: "Maths", Grades: []Grade{ds1}}, {Name: "Anglais", Grades: []Grade{oral}}}},
+				{Name: "S2", Courses: []Course{{Name: "Maths", Grades: []Grade{ds2}}}},
+			},
+			want: []Course{{Name: "Maths", Grades: []Grade{ds1, ds2}}, {Name: "Anglais", Grades: []Grade{oral}}},
+		},
+		{
+			name: "yearly period overlapping the semesters",
+			periods: []Period{
+				{Name: "S1", Courses: []Course{{Name: "Maths", Grades: []Grade{ds1}}}},
+				{Name: "S2", Courses: []Course{{Name: "Maths", Grades: []Grade{ds2}}}},
+				{Name: "Année", Courses: []Course{{Name: "Maths", Grades: []Grade{ds1, ds2}}}},
+			},
+			want: []Course{{Name: "Maths", Grades: []Grade{ds1, ds2}}},
+		},
+		{
+			name: "identical grades within a period",
+			periods: []Period{
+				{Name: "S1", Courses: []Course{{Name: "Maths", Grades: []Grade{tp, tp, ds1}}}},
+			},
+			want: []Course{{Name: "Maths", Grades: []Grade{tp, tp, ds1}}},
+		},
+		{
+			name: "identical grades within a period, listed again by the year",
+			periods: []Period{
+				{Name: "S1", Courses: []Course{{Name: "Maths", Grades: []Grade{tp, tp}}}},
+				{Name: "Année", Courses: []Course{{Name: "Maths", Grades: []Grade{tp, tp, tp}}}},
+			},
+			want: []Course{{Name: "Maths", Grades: []Grade{tp, tp, tp}}},
+		},
+		{
+			name: "same grade with other remarks",
+			periods: []Period{
+				{Name: "S1", Courses: []Course{{Name: "Maths", Grades: []Grade{ds1}}}},
+				{Name: "Année", Courses: []Course{{Name: "Maths", Grades: []Grade{withRemarks(ds1, "Bien")}}}},
+			},
+			want: []Course{{Name: "Maths", Grades: []Grade{ds1}}},
+		},
+		{
+			name: "same date in another time zone",
+			periods: []Period{
+				{Name: "S1", Courses: []Course{{Name: "Maths", Grades: []Grade{ds1}}}},
+				{Name: "Année", Courses: []Course{{Name: "Maths", Grades: []Grade{inLocation(ds1, time.FixedZone("UTC", 0))}}}},
+			},
+			want: []Course{{Name: "Maths", Grades: []Grade{ds1}}},
+		},
+		{
+			name: "course without grades",
+			periods: []Period{
+				{Name: "S1", Courses: []Course{{Name: "Sport"}}},
+			},
+			want: []Course{{Name: "Sport", Grades: []Grade{}}},
+		},
+		{
+			name: "no period",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MergePeriods(tt.periods)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d courses, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i := range got {
+				if got[i].Name != tt.want[i].Name || !slices.Equal(got[i].Grades, tt.want[i].Grades) {
+					t.Errorf("course %d:\n got %+v\nwant %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStudentForPeriod(t *testing.T) {
+	october := Absence{Date: date(2024, 10, 14), Course: "Maths"}
+	march := Absence{Date: date(2025, 3, 3), Course: "Anglais"}
+	lastDay := Absence{Date: date(2025, 1, 31), Course: "Maths"}
+
+	student := &Student{
+		Name:     "Jane Doe",
+		Profile:  Profile{Class: "BTS 1"},
+		Absences: []Absence{october, lastDay, march},
+		Periods: []Period{
+			{Name: "Semestre 1", Start: date(2024, 9, 2), End: date(2025, 1, 31), Average: 14, Courses: []Course{{Name: "Maths"}}},
+			{Name: "Semestre 2", Start: date(2025, 2, 1), End: date(2025, 6, 30), Average: 12, Courses: []Course{{Name: "Anglais"}}},
+			{Name: "Rattrapage", Average: 10, Courses: []Course{{Name: "Maths"}}},
+		},
+	}
+
+	tests := []struct {
+		period   string
+		average  float64
+		absences []Absence
+	}{
+		{"Semestre 1", 14, []Absence{october, lastDay}},
+		{"Semestre 2", 12, []Absence{march}},
+		// Without dates, every absence is kept
+		{"Rattrapage", 10, []Absence{october, lastDay, march}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			got := student.ForPeriod(tt.period)
+			if got == nil {
+				t.Fatal("no student for the period")
+			}
+			if got.Name != student.Name || got.Profile != student.Profile {
+				t.Errorf("student details not kept: %+v", got)
+			}
+			if got.TotalAverage != tt.average {
+				t.Errorf("average %g, want %g", got.TotalAverage, tt.average)
+			}
+			if !slices.Equal(got.Absences, tt.absences) {
+				t.Errorf("absences %+v, want %+v", got.Absences, tt.absences)
+			}
+			if len(got.Periods) != 0 {
+				t.Errorf("periods kept: %+v", got.Periods)
+			}
+		})
+	}
+
+	if got := student.ForPeriod("Trimestre 3"); got != nil {
+		t.Errorf("unknown period gave %+v", got)
+	}
+}
+
+func TestWeightedAverageScales(t *testing.T) {
+	tests := []struct {
+		name   string
+		grades []Grade
+		want   float64
+	}{
+		{"out of 20", []Grade{{Value: 12, OutOf: 20, Coefficient: 1, Status: GradeGraded}, {Value: 16, OutOf: 20, Coefficient: 3, Status: GradeGraded}}, 15},
+		{"out of 10", []Grade{{Value: 7.5, OutOf: 10, Coefficient: 1, Status: GradeGraded}}, 15},
+		{"out of 100", []Grade{{Value: 85, OutOf: 100, Coefficient: 2, Status: GradeGraded}, {Value: 11, OutOf: 20, Coefficient: 2, Status: GradeGraded}}, 14},
+		// Old exports have no maximum, their values are on the scale already
+		{"no maximum", []Grade{{Value: 13, Coefficient: 1, Status: GradeGraded}}, 13},
+		{"no coefficient", []Grade{{Value: 5, OutOf: 20, Status: GradeGraded}, {Value: 18, OutOf: 20, Coefficient: 1, Status: GradeGraded}}, 18},
+		{"no grade", nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := weightedAverage([]Course{{Name: "Maths", Grades: tt.grades}}); !approx(got, tt.want) {
+				t.Errorf("weightedAverage = %g, want %g", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWeightedAverageStatuses(t *testing.T) {
+	graded := Grade{Value: 16, OutOf: 20, Coefficient: 1, Status: GradeGraded}
+	zero := Grade{Value: 0, OutOf: 20, Coefficient: 1, Status: GradeGraded}
+	absent := Grade{OutOf: 20, Coefficient: 1, Status: GradeAbsent}
+	exempt := Grade{OutOf: 20, Coefficient: 1, Status: GradeExempt}
+	pending := Grade{OutOf: 20, Coefficient: 1, Status: GradePending}
+
+	tests := []struct {
+		name   string
+		zeros  []GradeStatus // Statuses counted as 0
+		grades []Grade
+		want   float64
+	}{
+		{"real zero", nil, []Grade{graded, zero}, 8},
+		{"absence left out", nil, []Grade{graded, absent}, 16},
+		{"exemption left out", nil, []Grade{graded, exempt}, 16},
+		{"pending left out", nil, []Grade{graded, pending}, 16},
+		{"absence counted as zero", []GradeStatus{GradeAbsent}, []Grade{graded, absent, exempt}, 8},
+		{"only absences", nil, []Grade{absent}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := zeroStatuses
+			t.Cleanup(func() { zeroStatuses = saved })
+			zeroStatuses = map[GradeStatus]bool{}
+			for _, status := range tt.zeros {
+				zeroStatuses[status] = true
+			}
+
+			if got := weightedAverage([]Course{{Name: "Maths", Grades: tt.grades}}); !approx(got, tt.want) {
+				t.Errorf("weightedAverage = %g, want %g", got, tt.want)
+			}
+		})
+	}
+}
+
+func withRemarks(grade Grade, remarks string) Grade {
+	grade.Remarks = remarks
+	return grade
+}
+
+func inLocation(grade Grade, loc *time.Location) Grade {
+	grade.Date = grade.Date.In(loc)
+	return grade
+}
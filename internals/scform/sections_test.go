@@ -0,0 +1,142 @@
+package scform
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// TestParseSections reads the pages the built-in profile leaves off with the profile of testdata/layout.json
+func TestParseSections(t *testing.T) {
+	profile := testLayout(t)
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2025, month, day, hour, minute, 0, 0, paris)
+	}
+	documentsURL, _ := url.Parse("https://sc.example/Eleve/MesDocuments.aspx")
+
+	home := func(doc *html.Node) any { return parseProfile(doc, profile) }
+	absences := func(doc *html.Node) any { return parseAbsences(doc, profile) }
+	timetable := func(doc *html.Node) any { return parseTimetable(doc, profile) }
+	documents := func(doc *html.Node) any { return findDocumentLinks(doc, profile, documentsURL) }
+
+	// Results are compared printed, which shows the zone of times too
+	tests := []struct {
+		name  string
+		parse func(*html.Node) any
+		doc   string // Fixture file, or markup when it starts with <
+		want  any
+	}{
+		{
+			name:  "home page",
+			parse: home,
+			doc:   "home.html",
+			want: Profile{
+				FullName:   "DOE Jane",
+				Formation:  "BTS Services Informatiques aux Organisations",
+				Class:      "SIO 1 - Groupe B",
+				Centre:     "CFA Paris Est",
+				SchoolYear: "2024-2025",
+			},
+		},
+		{
+			name:  "home page with other label names",
+			parse: home,
+			doc:   `<span id="LabelNomStagiaire">Jane Doe</span><span id="LabelDiplome">Licence pro</span><span id="LabelPromotion">2025</span>`,
+			want:  Profile{FullName: "Jane Doe", Formation: "Licence pro", Class: "2025"},
+		},
+		{
+			name:  "home page without details",
+			parse: home,
+			doc:   `<p>Bienvenue</p>`,
+			want:  Profile{},
+		},
+		{
+			name:  "absences page",
+			parse: absences,
+			doc:   "absences.html",
+			want: []Absence{
+				{Date: date(2024, 10, 14), Slot: "08h30 - 10h30", Course: "Mathématiques", Justified: true, Reason: "Certificat médical"},
+				{Date: date(2025, 3, 3), Slot: "13h30 - 13h45", Course: "Anglais", Late: true},
+				{Date: date(2025, 3, 18), Slot: "10h45 - 12h45", Course: "Économie", Justified: true, Reason: "Convocation"},
+			},
+		},
+		{
+			name:  "no absence",
+			parse: absences,
+			doc:   `<table id="MainContent_GridViewAbsences"><tr><td>Aucune absence</td></tr></table>`,
+			want:  []Absence{},
+		},
+		{
+			name:  "other page than the absences",
+			parse: absences,
+			doc:   `<p>Session expirée</p>`,
+			want:  []Absence{},
+		},
+		{
+			name:  "timetable page",
+			parse: timetable,
+			doc:   "timetable.html",
+			want: []TimetableEntry{
+				{Start: at(time.January, 6, 8, 30), End: at(time.January, 6, 12, 30), Course: "Mathématiques", Room: "B204", Teacher: "M. Martin"},
+				// The day daylight saving time starts
+				{Start: at(time.March, 30, 9, 0), End: at(time.March, 30, 17, 15), Course: "Anglais", Teacher: "Mme Smith"},
+			},
+		},
+		{
+			name:  "empty planning",
+			parse: timetable,
+			doc:   `<table id="MainContent_GridViewPlanning"><tr><th>Date</th></tr></table>`,
+			want:  []TimetableEntry{},
+		},
+		{
+			name:  "documents page",
+			parse: documents,
+			doc:   "documents.html",
+			want: []documentLink{
+				{Name: "Bulletin semestre 1", URL: "https://sc.example/Fichiers/Bulletin_S1.pdf", Index: 0},
+				{Name: "Attestation de scolarité", URL: "https://sc.example/Eleve/Document.ashx?id=42&type=attestation", Index: 1},
+				{Name: "Relevé de notes", Postback: true, Index: 2},
+			},
+		},
+		{
+			name:  "document links without text",
+			parse: documents,
+			doc:   `<a href="/Fichiers/Attestation.PDF"></a><a href="javascript:__doPostBack('Telecharger','')"></a>`,
+			want: []documentLink{
+				{Name: "Attestation.PDF", URL: "https://sc.example/Fichiers/Attestation.PDF", Index: 0},
+				{Name: "document", Postback: true, Index: 1},
+			},
+		},
+		{
+			name:  "no document",
+			parse: documents,
+			doc:   `<p>Aucun document disponible</p>`,
+			want:  []documentLink{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.parse(parseTestDoc(t, tt.doc))
+			if fmt.Sprintf("%T %+v", got, got) != fmt.Sprintf("%T %+v", tt.want, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestBuiltinSectionsOff checks the built-in profile reads none of the pages its selectors were not checked against
+func TestBuiltinSectionsOff(t *testing.T) {
+	profile := builtinLayouts()[0]
+	if profile.home != nil || profile.absences != nil || profile.timetable != nil || profile.documents != nil {
+		t.Errorf("built-in profile reads home %v, absences %v, timetable %v, documents %v",
+			profile.home != nil, profile.absences != nil, profile.timetable != nil, profile.documents != nil)
+	}
+}
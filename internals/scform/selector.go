@@ -0,0 +1,34 @@
+package scform
+
+import (
+	"fmt"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// cssSelector is a compiled CSS selector matched against parsed HTML, keeping its source
+// so the same selector can also be handed to the browser
+type cssSelector struct {
+	source string
+	sel    cascadia.SelectorGroup
+}
+
+// compileSelector parses a CSS selector
+func compileSelector(source string) (*cssSelector, error) {
+	sel, err := cascadia.ParseGroup(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %v", source, err)
+	}
+	return &cssSelector{source: source, sel: sel}, nil
+}
+
+// String returns the selector source
+func (s *cssSelector) String() string {
+	return s.source
+}
+
+// match reports whether the element matches the selector
+func (s *cssSelector) match(n *html.Node) bool {
+	return s.sel.Match(n)
+}
@@ -0,0 +1,167 @@
+package scform
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestCompileSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		html     string
+		want     bool
+	}{
+		{"table.AfficheInfoEnMieux", `<table class="Grille AfficheInfoEnMieux"></table>`, true},
+		{"table.AfficheInfoEnMieux", `<div class="AfficheInfoEnMieux"></div>`, false},
+		{"div[id='DivNOTE']", `<div id="DivNOTE"></div>`, true},
+		{"div[id='DivNOTE']", `<div id="DivNOTE2"></div>`, false},
+		{"span[id*='Label1']", `<span id="Repeater_Label1_0"></span>`, true},
+		{"span[id*='Label1']", `<span id="Repeater_label1_0"></span>`, false},
+		{"span[id*='labelclasse' i]", `<span id="MainContent_LabelClasse"></span>`, true},
+		{"a[href$='.pdf' i]", `<a href="/docs/Bulletin.PDF"></a>`, true},
+		{"a[href$='.pdf' i]", `<a href="/docs/bulletin.pdf?v=2"></a>`, false},
+		{"a[href^='/docs']", `<a href="/docs/bulletin.pdf"></a>`, true},
+		{"div[class~='alert']", `<div class="alert alert-danger"></div>`, true},
+		{"div[class~='alert']", `<div class="alert-danger"></div>`, false},
+		{"[routerlink*='notes']", `<li routerlink="/eleve/notes"></li>`, true},
+		{"input[type='radio'][value='1']", `<input type="radio" value="1">`, true},
+		{"input[type='radio'][value='1']", `<input type="checkbox" value="1">`, false},
+		{"table[id*='gridview' i] tr", `<table id="GridViewAbsence"><tr><td></td></tr></table>`, true},
+		{"table[id*='gridview' i] tr", `<table id="Other"><tr><td></td></tr></table>`, false},
+		{"form > input", `<form><input></form>`, true},
+		{"form > input", `<form><div><input></div></form>`, false},
+		{"#email, .login", `<div class="login"></div>`, true},
+		{"mat-error", `<mat-error>Mot de passe incorrect</mat-error>`, true},
+		{"[role='alert']", `<div role="alert"></div>`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector+" "+tt.html, func(t *testing.T) {
+			sel, err := compileSelector(tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := matchesIn(t, sel, tt.html); got != tt.want {
+				t.Errorf("match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileSelectorInvalid(t *testing.T) {
+	for _, selector := range []string{"", "span[id*=", "div >", "a[href='x' z]"} {
+		if _, err := compileSelector(selector); err == nil {
+			t.Errorf("%q compiled", selector)
+		}
+	}
+}
+
+// selectorCase is a layout selector and markup it must match
+type selectorCase struct {
+	name     string
+	selector string
+	html     []string // Each element must match on its own
+}
+
+// TestDefaultLayoutSelectors checks every selector of the built-in profile, including those only
+// handed to the browser, and the home selectors of testdata/layout.json against the markup it is meant for
+func TestDefaultLayoutSelectors(t *testing.T) {
+	profile := testLayout(t)
+	login, grades, home := profile.Login, profile.Grades, profile.Home
+	absences, timetable := profile.Absences, profile.Timetable
+
+	tests := []selectorCase{
+		{"login email", login.Email, []string{`<input id="email">`}},
+		{"login password", login.Password, []string{`<input id="password" type="password">`}},
+		{"login submit", login.Submit, []string{`<button type="submit">Connexion</button>`}},
+		{"login password change", login.PasswordChange, []string{`<input id="NewPassword">`, `<input name="newpassword">`, `<input id="confirmPassword">`}},
+		{"captcha detect", login.Challenges[0].Detect, []string{`<img src="/api/Captcha?id=1">`, `<img id="imgCaptcha">`, `<canvas id="captchaCanvas"></canvas>`}},
+		{"captcha input", login.Challenges[0].Input, []string{`<input id="CaptchaCode">`, `<input name="captcha">`}},
+		{"email code detect", login.Challenges[1].Detect, []string{`<input autocomplete="one-time-code">`, `<input id="VerificationCode">`, `<input name="verificationcode">`, `<input id="otpInput">`}},
+		{"email code input", login.Challenges[1].Input, []string{`<input autocomplete="one-time-code">`, `<input id="otp">`}},
+		{"challenge submit", login.Challenges[1].Submit, []string{`<button type="submit">Valider</button>`}},
+		{"confirm device submit", login.Challenges[2].Submit, []string{`<button id="TrustDevice"></button>`}},
+		{"confirm device", login.Challenges[2].Detect, []string{`<button id="btnTrustDevice"></button>`, `<button id="ConfirmDevice"></button>`, `<button id="rememberDevice"></button>`}},
+		{"home full name", home.FullName, []string{`<span id="MainContent_LabelNomPrenom">`, `<span id="LabelNomStagiaire">`, `<span id="labelidentite">`}},
+		{"home formation", home.Formation, []string{`<span id="MainContent_LabelFormation">`, `<span id="LabelDiplome">`}},
+		{"home class", home.Class, []string{`<span id="LabelClasse">`, `<span id="LabelPromotion">`, `<span id="LabelGroupe">`}},
+		{"home centre", home.Centre, []string{`<span id="LabelCentre">`, `<span id="LabelEtablissement">`, `<span id="LabelSite">`}},
+		{"home school year", home.SchoolYear, []string{`<span id="MainContent_LabelAnneeScolaire">`}},
+		{"grades course table", grades.CourseTable, []string{`<table class="AfficheInfoEnMieux">`}},
+		{"grades course name", grades.CourseName, []string{`<span id="Repeater1_NomCompletLabel_0">`}},
+		{"grades block", grades.GradeBlock, []string{`<div id="DivNOTE">`}},
+		{"grade value", grades.Fields.Value, []string{`<span id="Repeater2_Label1_0">`}},
+		{"grade coefficient", grades.Fields.Coefficient, []string{`<span id="Repeater2_Label3_0">`}},
+		{"grade title", grades.Fields.Title, []string{`<span id="Repeater2_Label7_0">`}},
+		{"grade type", grades.Fields.Type, []string{`<span id="Repeater2_Label8_0">`}},
+		{"grade remarks", grades.Fields.Remarks, []string{`<span id="Repeater2_Label9_0">`}},
+		{"grade observation", grades.Fields.Observation, []string{`<span id="Repeater2_Label10_0">`}},
+		{"grades period select", grades.PeriodSelect, []string{`<select id="MainContent_DropDownListPeriode">`, `<select name="ctl00$Periode">`}},
+		{"absences row", absences.Row, []string{`<table id="MainContent_GridViewAbsences"><tr><td></td></tr></table>`}},
+		{"absence date", absences.Fields.Date, []string{`<span id="LabelDate_0">`}},
+		{"absence slot", absences.Fields.Slot, []string{`<span id="LabelHoraire_0">`, `<span id="LabelCreneau_0">`}},
+		{"absence course", absences.Fields.Course, []string{`<span id="LabelMatiere_0">`, `<span id="LabelCours_0">`}},
+		{"absence justified", absences.Fields.Justified, []string{`<span id="LabelJustifiee_0">`}},
+		{"absence reason", absences.Fields.Reason, []string{`<span id="LabelMotif_0">`}},
+		{"absence type", absences.Fields.Type, []string{`<span id="LabelType_0">`}},
+		{"timetable row", timetable.Row, []string{`<table id="GridViewPlanning"><tr><td></td></tr></table>`}},
+		{"timetable date", timetable.Fields.Date, []string{`<span id="LabelDate_0">`}},
+		{"timetable time", timetable.Fields.Time, []string{`<span id="LabelHoraire_0">`, `<span id="LabelHeure_0">`}},
+		{"timetable course", timetable.Fields.Course, []string{`<span id="LabelMatiere_0">`, `<span id="LabelCours_0">`}},
+		{"timetable room", timetable.Fields.Room, []string{`<span id="LabelSalle_0">`}},
+		{"timetable teacher", timetable.Fields.Teacher, []string{`<span id="LabelFormateur_0">`, `<span id="LabelIntervenant_0">`}},
+		{"documents link", profile.Documents.Link, []string{`<a href="/files/Bulletin_S1.PDF">`, `<a href="Telecharger.aspx?id=3">`, `<a href="Document.ashx?id=3">`}},
+	}
+	errors := map[string]string{
+		"[role='alert']":    `<div role="alert">`,
+		".alert-danger":     `<div class="alert alert-danger">`,
+		".alert-error":      `<div class="alert-error">`,
+		".error-message":    `<p class="error-message">`,
+		".invalid-feedback": `<div class="invalid-feedback">`,
+		".text-danger":      `<span class="text-danger">`,
+		"mat-error":         `<mat-error>`,
+		".mat-error":        `<div class="mat-error">`,
+		".toast-error":      `<div class="toast toast-error">`,
+	}
+	for _, selector := range login.Errors {
+		tests = append(tests, selectorCase{"login error " + selector, selector, []string{errors[selector]}})
+	}
+	for _, selector := range grades.NavigationLinks {
+		tests = append(tests, selectorCase{"navigation link " + selector, selector, []string{`<a href="/Eleve/notes/grades" routerlink="/notes/grades">`}})
+	}
+	for _, selector := range grades.DisplayMode {
+		tests = append(tests, selectorCase{"display mode " + selector, selector, []string{`<input type="radio" id="MainContent_RadioButtonAffichage_1" name="affichage_display" value="1">`}})
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, err := compileSelector(tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.html) == 0 || tt.html[0] == "" {
+				t.Fatal("no markup to check the selector against")
+			}
+			for _, markup := range tt.html {
+				if !matchesIn(t, sel, markup) {
+					t.Errorf("%s does not match %s", tt.selector, markup)
+				}
+			}
+			if matchesIn(t, sel, `<div id="unrelated" class="unrelated"><span>text</span></div>`) {
+				t.Errorf("%s matches unrelated markup", tt.selector)
+			}
+		})
+	}
+}
+
+// matchesIn reports whether an element of the markup matches the selector
+func matchesIn(t *testing.T, sel *cssSelector, markup string) bool {
+	t.Helper()
+	doc, err := html.Parse(strings.NewReader("<html><body>" + markup + "</body></html>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return findFirst(doc, sel.match) != nil
+}
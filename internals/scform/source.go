@@ -0,0 +1,215 @@
+package scform
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Credentials holds what a GradeSource needs to log into SCForm
+type Credentials struct {
+	URL      string // SC-Connect login URL
+	Username string
+	Password string
+
+	// Challenges answers the challenges SC-Connect may show during the login, like a captcha.
+	// Without it, a login stopped at a challenge fails.
+	Challenges ChallengeSolver
+}
+
+// ProgressSink receives progress updates while a GradeSource is fetching grades
+type ProgressSink func(update ProgressUpdate)
+
+// Send forwards an update to the sink, doing nothing on a nil sink
+func (s ProgressSink) Send(update ProgressUpdate) {
+	if s != nil {
+		s(update)
+	}
+}
+
+// ChanSink adapts a progress channel to a ProgressSink, a nil channel gives a nil sink
+func ChanSink(ch chan<- ProgressUpdate) ProgressSink {
+	if ch == nil {
+		return nil
+	}
+	return func(update ProgressUpdate) {
+		ch <- update
+	}
+}
+
+// GradeSource is a backend able to retrieve the grades of a student
+type GradeSource interface {
+	Fetch(ctx context.Context, creds Credentials, progress ProgressSink) (*Student, error)
+}
+
+// GradeSourceFactory creates a GradeSource from the environment
+type GradeSourceFactory func() (GradeSource, error)
+
+var (
+	gradeSources    = make(map[string]GradeSourceFactory)
+	gradeSourcesMux sync.RWMutex
+)
+
+func init() {
+	RegisterGradeSource("rod", func() (GradeSource, error) {
+		source := &RodSource{Diagnostics: NewDiagnosticStoreFromEnv(), Filter: NewRequestFilterFromEnv()}
+		if err := source.sessionFilesFromEnv(); err != nil {
+			return nil, err
+		}
+		if source.RecordDir == "" && source.ReplayPath == "" {
+			source.Pool = NewBrowserPoolFromEnv()
+			source.Logins = NewLoginStoreFromEnv()
+		}
+		return source, nil
+	})
+	RegisterGradeSource("file", func() (GradeSource, error) {
+		path := os.Getenv("SCFORM_FIXTURE_PATH")
+		if path == "" {
+			return nil, fmt.Errorf("SCFORM_FIXTURE_PATH is required for the file grade source")
+		}
+		return &FileSource{Path: path}, nil
+	})
+}
+
+// RegisterGradeSource makes a GradeSource available under the given name
+func RegisterGradeSource(name string, factory GradeSourceFactory) {
+	gradeSourcesMux.Lock()
+	defer gradeSourcesMux.Unlock()
+	gradeSources[name] = factory
+}
+
+// GradeSourceNames returns the names of all registered grade sources
+func GradeSourceNames() []string {
+	gradeSourcesMux.RLock()
+	defer gradeSourcesMux.RUnlock()
+
+	names := make([]string, 0, len(gradeSources))
+	for name := range gradeSources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewGradeSource creates the grade source registered under name
+func NewGradeSource(name string) (GradeSource, error) {
+	gradeSourcesMux.RLock()
+	factory, ok := gradeSources[name]
+	gradeSourcesMux.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown grade source %q (available: %s)", name, strings.Join(GradeSourceNames(), ", "))
+	}
+	return factory()
+}
+
+// NewGradeSourceFromEnv creates the grade source selected by SCFORM_SOURCE, defaulting to rod
+func NewGradeSourceFromEnv() (GradeSource, error) {
+	name := strings.ToLower(strings.TrimSpace(os.Getenv("SCFORM_SOURCE")))
+	if name == "" {
+		name = "rod"
+	}
+	DebugLog("Using grade source: %s", name)
+	return NewGradeSource(name)
+}
+
+// RodSource retrieves grades by driving a Chromium browser with go-rod.
+// With a Pool, each retrieval gets an incognito context of a shared browser,
+// otherwise it starts and closes its own browser. With Diagnostics, a bundle is
+// captured for every failed attempt. With RecordDir, the HTTP exchanges of each
+// retrieval are archived there, and with ReplayPath such an archive is served
+// instead of the network. Both use a browser of their own, not the pool. With Filter,
+// the requests a retrieval does not need are blocked, and with Logins, the cookies of a
+// login are kept so the next retrieval of the same user can skip it.
+type RodSource struct {
+	Pool        *BrowserPool
+	Diagnostics *DiagnosticStore
+	Filter      *RequestFilter
+	Logins      *LoginStore
+	RecordDir   string
+	ReplayPath  string
+}
+
+// FileSource serves grades from a saved MesNotes.aspx page or a JSON export.
+// If Path is a directory, the file is looked up by username (<username>.html or <username>.json).
+type FileSource struct {
+	Path string
+}
+
+// Fetch reads and parses the fixture file
+func (s *FileSource) Fetch(ctx context.Context, creds Credentials, progress ProgressSink) (*Student, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	path, err := s.resolve(creds.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	// A single step, timed so the ETA of the next reads is known
+	tracker := newStepTracker(progress, fileSteps, fileHistory)
+	progress = tracker.sink()
+	tracker.step(StepExtract)
+
+	progress.Send(ProgressUpdate{
+		Status:  "fetching_grades",
+		Message: "Reading grades from file...",
+	})
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open grades file: %v", err)
+	}
+	defer f.Close()
+
+	var student *Student
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		student = &Student{}
+		if err := json.NewDecoder(f).Decode(student); err != nil {
+			return nil, fmt.Errorf("failed to decode grades file: %v", err)
+		}
+		student.CalculateTotalAverage()
+	} else {
+		student, err = ParseGradesHTML(f)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if student.Name == "" {
+		student.Name = creds.Username
+	}
+
+	tracker.finish()
+	progress.Send(ProgressUpdate{
+		Status:  "complete",
+		Message: "Done!",
+	})
+
+	return student, nil
+}
+
+// resolve returns the file to read for the given user
+func (s *FileSource) resolve(username string) (string, error) {
+	info, err := os.Stat(s.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to access grades fixture: %v", err)
+	}
+	if !info.IsDir() {
+		return s.Path, nil
+	}
+
+	for _, ext := range []string{".html", ".json"} {
+		candidate := filepath.Join(s.Path, filepath.Base(username)+ext)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no grades fixture found for %s in %s", username, s.Path)
+}
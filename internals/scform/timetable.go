@@ -0,0 +1,78 @@
+package scform
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-rod/rod"
+	"golang.org/x/net/html"
+)
+
+// sessionTimeRegex finds the hh:mm or hhhmm times of a session in its time field
+var sessionTimeRegex = regexp.MustCompile(`(\d{1,2})\s*[h:]\s*(\d{2})`)
+
+// parseTimetable reads the sessions listed on the timetable page. Rows without a date and
+// both a start and an end time, like the header of the table, are skipped.
+func parseTimetable(doc *html.Node, profile *LayoutProfile) []TimetableEntry {
+	sel := profile.timetable
+	if sel == nil {
+		return nil
+	}
+
+	text := func(row *html.Node, field *cssSelector) string {
+		if field == nil {
+			return ""
+		}
+		return strings.TrimSpace(selectText(row, field))
+	}
+
+	var entries []TimetableEntry
+	for _, row := range findAll(doc, sel.row.match) {
+		day, err := time.ParseInLocation("02/01/2006", dateRegex.FindString(text(row, sel.date)), sel.location)
+		if err != nil {
+			continue
+		}
+		times := sessionTimeRegex.FindAllStringSubmatch(text(row, sel.time), 2)
+		if len(times) < 2 {
+			continue
+		}
+
+		entries = append(entries, TimetableEntry{
+			Start:   atTime(day, times[0]),
+			End:     atTime(day, times[1]),
+			Course:  text(row, sel.course),
+			Room:    text(row, sel.room),
+			Teacher: text(row, sel.teacher),
+		})
+	}
+
+	if len(entries) == 0 {
+		warnEmptyPage(profile, profile.Timetable.Page, "timetable session")
+	}
+	return entries
+}
+
+// atTime returns the day at the hour and minute groups matched by sessionTimeRegex
+func atTime(day time.Time, match []string) time.Time {
+	hour, _ := strconv.Atoi(match[1])
+	minute, _ := strconv.Atoi(match[2])
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, day.Location())
+}
+
+// fetchTimetable opens the timetable page of the profile in the browser and parses it
+func fetchTimetable(page *rod.Page, homeURL string, profile *LayoutProfile) ([]TimetableEntry, error) {
+	if profile.timetable == nil {
+		return nil, nil
+	}
+
+	doc, err := openProfilePage(page, homeURL, profile.Timetable.Page, StepTimetable)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := parseTimetable(doc, profile)
+	DebugLog("Found %d timetable sessions", len(entries))
+	return entries, nil
+}
@@ -11,12 +11,16 @@ func TestChromeDevWS(wsURL string) (err error) {
 
 	log.Println("Testing Chrome Dev WS:", wsURL)
 
-	conn, _, _, err := ws.Dial(context.Background(), wsURL)
+	return TestChromeDevWSContext(context.Background(), wsURL)
+}
+
+// TestChromeDevWSContext checks that a Chrome DevTools WebSocket accepts connections, giving up when ctx is done
+func TestChromeDevWSContext(ctx context.Context, wsURL string) error {
+	conn, _, _, err := ws.Dial(ctx, wsURL)
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
 
 	return nil
-
 }
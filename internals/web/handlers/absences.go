@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"scrapping/internals/scform"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// absenceSummary counts the absences shown in the absences view
+type absenceSummary struct {
+	Absences    int // Absences, lateness excluded
+	Late        int // Lateness
+	Unjustified int // Absences and lateness not justified
+}
+
+// summarizeAbsences counts the absences, lateness and unjustified ones
+func summarizeAbsences(absences []scform.Absence) absenceSummary {
+	var summary absenceSummary
+	for _, absence := range absences {
+		if absence.Late {
+			summary.Late++
+		} else {
+			summary.Absences++
+		}
+		if !absence.Justified {
+			summary.Unjustified++
+		}
+	}
+	return summary
+}
+
+// HandleAbsences renders the absences view of the current student, for the whole year or one period
+func (h *GradeHandler) HandleAbsences(c *fiber.Ctx) error {
+	student, err := h.getStudentView(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if student == nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "No grades data available",
+		})
+	}
+
+	return c.Render("partials/absences", fiber.Map{
+		"Absences": student.Absences,
+		"Summary":  summarizeAbsences(student.Absences),
+	}, "")
+}
+
+// HandleAbsencesAPI returns the absences of the current student as JSON, for the whole year or one period
+func (h *GradeHandler) HandleAbsencesAPI(c *fiber.Ctx) error {
+	student, err := h.getStudentView(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if student == nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "No grades data available",
+		})
+	}
+
+	absences := []map[string]interface{}{}
+	for _, absence := range student.Absences {
+		absences = append(absences, map[string]interface{}{
+			"date":          absence.Date.Format("2006-01-02"),
+			"dateFormatted": absence.Date.Format("02/01/06"),
+			"slot":          absence.Slot,
+			"course":        absence.Course,
+			"late":          absence.Late,
+			"justified":     absence.Justified,
+			"reason":        absence.Reason,
+		})
+	}
+
+	summary := summarizeAbsences(student.Absences)
+	return c.JSON(fiber.Map{
+		"absences":    absences,
+		"total":       summary.Absences,
+		"late":        summary.Late,
+		"unjustified": summary.Unjustified,
+	})
+}
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"scrapping/internals/scform"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleBrowsers reports the state of the remote browser endpoints and of the browser pool
+func (h *GradeHandler) HandleBrowsers(c *fiber.Ctx) error {
+	data := fiber.Map{
+		"endpoints": []scform.RemoteEndpointState{},
+	}
+
+	if remote := scform.RemoteBrowsers(); remote != nil {
+		data["endpoints"] = remote.States()
+	}
+	if rodSource, ok := h.source.(*scform.RodSource); ok && rodSource.Pool != nil {
+		data["pool"] = rodSource.Pool.Stats()
+	}
+
+	return c.JSON(data)
+}
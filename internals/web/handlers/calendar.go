@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"scrapping/internals/scform"
+	"scrapping/internals/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+)
+
+// calendarTokenRegex matches the tokens of the calendar subscriptions
+var calendarTokenRegex = regexp.MustCompile(`^[A-Za-z0-9]{32}$`)
+
+// CalendarStore keeps the timetables served to calendar apps by subscription token, one file
+// per token, so a subscription keeps working without a session and across restarts.
+type CalendarStore struct {
+	Dir string
+
+	mu sync.Mutex
+}
+
+// calendarSnapshot is the timetable served for a subscription token
+type calendarSnapshot struct {
+	Name      string                  `json:"name"`
+	Timetable []scform.TimetableEntry `json:"timetable"`
+	Updated   time.Time               `json:"updated"`
+}
+
+// NewCalendarStoreFromEnv creates a store in SCFORM_CALENDAR_DIR, defaulting to the system temp directory
+func NewCalendarStoreFromEnv() *CalendarStore {
+	dir := os.Getenv("SCFORM_CALENDAR_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "scform-calendars")
+	}
+	return &CalendarStore{Dir: dir}
+}
+
+// path returns the file of a token, rejecting anything that is not a token
+func (s *CalendarStore) path(token string) (string, error) {
+	if !calendarTokenRegex.MatchString(token) {
+		return "", fmt.Errorf("invalid calendar token")
+	}
+	return filepath.Join(s.Dir, token+".json"), nil
+}
+
+// Save stores the timetable of the student under the token, replacing the previous one
+func (s *CalendarStore) Save(token string, student *scform.Student) error {
+	path, err := s.path(token)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(calendarSnapshot{
+		Name:      student.Name,
+		Timetable: student.Timetable,
+		Updated:   time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// Load returns the timetable stored under the token
+func (s *CalendarStore) Load(token string) (*calendarSnapshot, error) {
+	path, err := s.path(token)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	data, err := os.ReadFile(path)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot calendarSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse calendar %s: %v", token, err)
+	}
+	return &snapshot, nil
+}
+
+// Delete removes the timetable stored under the token, which stops working
+func (s *CalendarStore) Delete(token string) error {
+	path, err := s.path(token)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// refreshSubscription updates the calendar subscribed to from the session, if any, with the new student data
+func (h *GradeHandler) refreshSubscription(sess *session.Session, student *scform.Student) {
+	token, _ := sess.Get("calendarToken").(string)
+	if token == "" {
+		return
+	}
+	if err := h.calendars.Save(token, student); err != nil {
+		log.Printf("Failed to refresh calendar subscription: %v", err)
+	}
+}
+
+// subscriptionURLs returns the https and webcal URLs of a subscription token
+func subscriptionURLs(c *fiber.Ctx, token string) (string, string) {
+	feedURL := c.BaseURL() + "/calendar/" + token + ".ics"
+	webcalURL := "webcal" + strings.TrimPrefix(strings.TrimPrefix(feedURL, "https"), "http")
+	return feedURL, webcalURL
+}
+
+// HandleTimetableICS downloads the timetable of the current student as an iCalendar file
+func (h *GradeHandler) HandleTimetableICS(c *fiber.Ctx) error {
+	student := h.getCurrentStudent(c)
+	if student == nil || len(student.Timetable) == 0 {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "No timetable available",
+		})
+	}
+
+	c.Set("Content-Type", "text/calendar; charset=utf-8")
+	c.Set("Content-Disposition", "attachment; filename=timetable.ics")
+	return c.Send(scform.ExportToICS(student))
+}
+
+// HandleCreateSubscription returns the subscription URL of the timetable of the current student,
+// creating it on first use. The URL keeps serving the timetable of the last retrieval of the session.
+func (h *GradeHandler) HandleCreateSubscription(c *fiber.Ctx) error {
+	student := h.getCurrentStudent(c)
+	if student == nil || len(student.Timetable) == 0 {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "No timetable available",
+		})
+	}
+
+	sess, err := h.sessionManager.Store.Get(c)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to get session",
+		})
+	}
+
+	token, _ := sess.Get("calendarToken").(string)
+	if token == "" {
+		if token, err = utils.CreateShortLink(32); err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Failed to create calendar token",
+			})
+		}
+	}
+
+	if err := h.calendars.Save(token, student); err != nil {
+		log.Printf("Failed to save calendar subscription: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to save calendar",
+		})
+	}
+
+	sess.Set("calendarToken", token)
+	if err := sess.Save(); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to save session",
+		})
+	}
+
+	feedURL, webcalURL := subscriptionURLs(c, token)
+	return c.JSON(fiber.Map{
+		"url":    feedURL,
+		"webcal": webcalURL,
+	})
+}
+
+// HandleDeleteSubscription revokes the calendar subscription of the current session
+func (h *GradeHandler) HandleDeleteSubscription(c *fiber.Ctx) error {
+	sess, err := h.sessionManager.Store.Get(c)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to get session",
+		})
+	}
+
+	token, _ := sess.Get("calendarToken").(string)
+	if token == "" {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "No calendar subscription",
+		})
+	}
+
+	if err := h.calendars.Delete(token); err != nil {
+		log.Printf("Failed to delete calendar subscription: %v", err)
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to delete calendar",
+		})
+	}
+
+	sess.Delete("calendarToken")
+	if err := sess.Save(); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to save session",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "deleted",
+	})
+}
+
+// HandleCalendarFeed serves the timetable of a subscription token to calendar apps, without a session
+func (h *GradeHandler) HandleCalendarFeed(c *fiber.Ctx) error {
+	snapshot, err := h.calendars.Load(c.Params("token"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Calendar not found",
+		})
+	}
+
+	c.Set("Content-Type", "text/calendar; charset=utf-8")
+	c.Set("Last-Modified", snapshot.Updated.UTC().Format(http.TimeFormat))
+	return c.Send(scform.ExportToICS(&scform.Student{
+		Name:      snapshot.Name,
+		Timetable: snapshot.Timetable,
+	}))
+}
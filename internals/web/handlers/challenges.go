@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"scrapping/internals/scform"
+	"scrapping/internals/utils"
+)
+
+// challengeMessages holds the French instructions of each kind of login challenge
+var challengeMessages = map[string]string{
+	scform.ChallengeCaptcha:       "SCForm demande de recopier le code affiché dans l'image.",
+	scform.ChallengeEmailCode:     "SCForm a envoyé un code de vérification par e-mail, saisissez-le pour continuer.",
+	scform.ChallengeConfirmDevice: "SCForm demande de confirmer cet appareil pour continuer.",
+}
+
+// ChallengeEvent is sent to a session whose login stopped at a challenge. The page answers it
+// with a challenge_answer message carrying the same ID.
+type ChallengeEvent struct {
+	Status      string  `json:"status"` // Always "challenge"
+	ID          string  `json:"id"`
+	Kind        string  `json:"kind"`
+	Message     string  `json:"message"`
+	NeedsAnswer bool    `json:"needsAnswer"`
+	Screenshot  string  `json:"screenshot"` // PNG data URL of the page showing the challenge
+	Timeout     float64 `json:"timeout"`    // Seconds left to answer
+}
+
+// clientMessage is a message sent by the page over the WebSocket
+type clientMessage struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Answer string `json:"answer"`
+	Cancel bool   `json:"cancel"` // The user refused the challenge
+}
+
+// pendingChallenge is a challenge waiting for the answer of its session
+type pendingChallenge struct {
+	event   ChallengeEvent
+	answers chan clientMessage
+}
+
+var (
+	// challenges holds the challenge each session has to answer, if any
+	challenges    = make(map[string]*pendingChallenge)
+	challengesMux sync.Mutex
+)
+
+// relayChallenge sends a login challenge to the pages of the session and waits for their answer
+func relayChallenge(ctx context.Context, sessionID string, challenge scform.Challenge) (string, error) {
+	id, err := utils.CreateShortLink(16)
+	if err != nil {
+		return "", err
+	}
+
+	pending := &pendingChallenge{
+		event: ChallengeEvent{
+			Status:      "challenge",
+			ID:          id,
+			Kind:        challenge.Kind,
+			Message:     challengeMessages[challenge.Kind],
+			NeedsAnswer: challenge.NeedsAnswer,
+			Screenshot:  "data:image/png;base64," + base64.StdEncoding.EncodeToString(challenge.Screenshot),
+		},
+		answers: make(chan clientMessage, 1),
+	}
+	if pending.event.Message == "" {
+		pending.event.Message = "SCForm demande une vérification pour continuer."
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		pending.event.Timeout = time.Until(deadline).Seconds()
+	}
+
+	challengesMux.Lock()
+	challenges[sessionID] = pending
+	challengesMux.Unlock()
+	defer func() {
+		challengesMux.Lock()
+		if challenges[sessionID] == pending {
+			delete(challenges, sessionID)
+		}
+		challengesMux.Unlock()
+	}()
+
+	log.Printf("Login of session %s stopped at a %s challenge, waiting for the answer", sessionID, challenge.Kind)
+	sendToSession(sessionID, pending.event)
+
+	select {
+	case msg := <-pending.answers:
+		if msg.Cancel {
+			return "", errors.New("refused by the user")
+		}
+		return msg.Answer, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// answerChallenge hands an answer sent over the WebSocket to the challenge of the session it answers
+func answerChallenge(sessionID string, msg clientMessage) {
+	challengesMux.Lock()
+	pending := challenges[sessionID]
+	challengesMux.Unlock()
+
+	if pending == nil || pending.event.ID != msg.ID {
+		log.Printf("Ignoring answer to unknown challenge %q for session %s", msg.ID, sessionID)
+		return
+	}
+	// Only the first answer counts
+	select {
+	case pending.answers <- msg:
+	default:
+	}
+}
+
+// resendChallenge sends the challenge the session has to answer again, for a page that reconnected
+func resendChallenge(sessionID string) {
+	challengesMux.Lock()
+	pending := challenges[sessionID]
+	challengesMux.Unlock()
+
+	if pending != nil {
+		sendToSession(sessionID, pending.event)
+	}
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"scrapping/internals/scform"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleDiagnostic downloads the diagnostic bundle of a failed retrieval as a zip
+func (h *GradeHandler) HandleDiagnostic(c *fiber.Ctx) error {
+	rodSource, ok := h.source.(*scform.RodSource)
+	if !ok || rodSource.Diagnostics == nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Diagnostics are disabled",
+		})
+	}
+
+	id := c.Params("id")
+	path, err := rodSource.Diagnostics.Path(id)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Diagnostic not found",
+		})
+	}
+
+	return c.Download(path, "diagnostic-"+id+".zip")
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"scrapping/internals/scform"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// documentIDRegex matches the IDs of the stored documents
+var documentIDRegex = regexp.MustCompile(`^[A-Za-z0-9]{32}$`)
+
+// DocumentStore keeps the files of the official documents downloaded from SCForm, one file
+// per document, as the session only keeps their metadata. Files are named after a hash of
+// their content, so a document downloaded again is stored once. Files older than Retention
+// are removed on the next save.
+type DocumentStore struct {
+	Dir       string
+	Retention time.Duration
+
+	mu sync.Mutex
+}
+
+// NewDocumentStoreFromEnv creates a store in SCFORM_DOCUMENTS_DIR, defaulting to the system temp
+// directory, keeping files for SCFORM_DOCUMENTS_RETENTION, defaulting to a week
+func NewDocumentStoreFromEnv() *DocumentStore {
+	dir := os.Getenv("SCFORM_DOCUMENTS_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "scform-documents")
+	}
+
+	retention := 7 * 24 * time.Hour
+	if value := os.Getenv("SCFORM_DOCUMENTS_RETENTION"); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil && parsed > 0 {
+			retention = parsed
+		} else {
+			log.Printf("Invalid SCFORM_DOCUMENTS_RETENTION %q, using %s", value, retention)
+		}
+	}
+
+	return &DocumentStore{Dir: dir, Retention: retention}
+}
+
+// path returns the file of a document, rejecting anything that is not a document ID
+func (s *DocumentStore) path(id string) (string, error) {
+	if !documentIDRegex.MatchString(id) {
+		return "", fmt.Errorf("invalid document ID")
+	}
+	return filepath.Join(s.Dir, id), nil
+}
+
+// Save stores the content of a document and returns its ID, the ID it already has if it is stored
+func (s *DocumentStore) Save(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	id := hex.EncodeToString(sum[:])[:32]
+	path, err := s.path(id)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return "", err
+	}
+	s.prune()
+
+	// A document stored already only gets its retention renewed
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil {
+		return id, nil
+	}
+
+	// Write to a temporary file first so a failed write never leaves a partial document under its ID
+	tmp, err := os.CreateTemp(s.Dir, id+"-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return id, os.Rename(tmp.Name(), path)
+}
+
+// Load returns the content of a stored document
+func (s *DocumentStore) Load(id string) ([]byte, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return os.ReadFile(path)
+}
+
+// prune removes the documents older than the retention, the caller holds the lock
+func (s *DocumentStore) prune() {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !documentIDRegex.MatchString(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < s.Retention {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.Dir, entry.Name())); err != nil {
+			log.Printf("Failed to remove expired document: %v", err)
+		}
+	}
+}
+
+// storeDocuments moves the content of the downloaded documents of the student to the store,
+// leaving their IDs. Documents that cannot be stored are dropped.
+func (h *GradeHandler) storeDocuments(student *scform.Student) {
+	var stored []scform.Document
+	for _, document := range student.Documents {
+		id, err := h.documents.Save(document.Data)
+		if err != nil {
+			log.Printf("Failed to store document %s: %v", document.Name, err)
+			continue
+		}
+		document.ID = id
+		document.Data = nil
+		stored = append(stored, document)
+	}
+	student.Documents = stored
+}
+
+// HandleDocuments renders the list of the official documents of the current student
+func (h *GradeHandler) HandleDocuments(c *fiber.Ctx) error {
+	student := h.getCurrentStudent(c)
+	if student == nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "No grades data available",
+		})
+	}
+
+	return c.Render("partials/documents", fiber.Map{
+		"Documents": student.Documents,
+	}, "")
+}
+
+// HandleDocumentsAPI returns the official documents of the current student as JSON
+func (h *GradeHandler) HandleDocumentsAPI(c *fiber.Ctx) error {
+	student := h.getCurrentStudent(c)
+	if student == nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "No grades data available",
+		})
+	}
+
+	documents := []map[string]interface{}{}
+	for _, document := range student.Documents {
+		documents = append(documents, map[string]interface{}{
+			"id":          document.ID,
+			"name":        document.Name,
+			"fileName":    document.FileName,
+			"contentType": document.ContentType,
+			"size":        document.Size,
+			"url":         "/documents/" + document.ID,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"documents": documents,
+		"total":     len(documents),
+	})
+}
+
+// HandleDocumentDownload sends a document of the current student. Only the documents of the
+// session can be downloaded, whatever is in the store.
+func (h *GradeHandler) HandleDocumentDownload(c *fiber.Ctx) error {
+	student := h.getCurrentStudent(c)
+	if student == nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "No grades data available",
+		})
+	}
+
+	id := c.Params("id")
+	for _, document := range student.Documents {
+		if document.ID == "" || document.ID != id {
+			continue
+		}
+
+		data, err := h.documents.Load(id)
+		if err != nil {
+			log.Printf("Failed to load document %s: %v", id, err)
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Document no longer available",
+			})
+		}
+
+		fileName := strings.ReplaceAll(document.FileName, `"`, "")
+		c.Set("Content-Type", document.ContentType)
+		c.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+		return c.Send(data)
+	}
+
+	return c.Status(404).JSON(fiber.Map{
+		"error": "Document not found",
+	})
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDocumentStoreSave(t *testing.T) {
+	store := &DocumentStore{Dir: t.TempDir(), Retention: time.Hour}
+
+	first, err := store.Save([]byte("bulletin S1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := store.Save([]byte("bulletin S1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := store.Save([]byte("bulletin S2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again != first {
+		t.Errorf("same content stored under %s and %s", first, again)
+	}
+	if other == first {
+		t.Errorf("other content stored under the same ID %s", first)
+	}
+	if !documentIDRegex.MatchString(first) {
+		t.Errorf("invalid ID %q", first)
+	}
+
+	entries, err := os.ReadDir(store.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("%d files stored, want 2", len(entries))
+	}
+
+	data, err := store.Load(first)
+	if err != nil || string(data) != "bulletin S1" {
+		t.Errorf("Load = %q, %v", data, err)
+	}
+	if _, err := store.Load("../../etc/passwd"); err == nil {
+		t.Error("loaded a path outside the store")
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	"scrapping/internals/scform"
+)
+
+// stepNames holds the French label of each scraping step
+var stepNames = map[string]string{
+	scform.StepConnect:     "connexion au navigateur",
+	scform.StepOpenLogin:   "ouverture de la page de connexion",
+	scform.StepLogin:       "connexion à SCForm",
+	scform.StepNavigate:    "accès à la page des notes",
+	scform.StepDisplayMode: "changement d'affichage des notes",
+	scform.StepExtract:     "lecture des notes",
+	scform.StepAbsences:    "lecture des absences",
+	scform.StepTimetable:   "lecture de l'emploi du temps",
+	scform.StepDocuments:   "téléchargement des documents",
+}
+
+// ErrorMessage turns a grade retrieval error into an actionable message for the user
+func ErrorMessage(err error) string {
+	var message string
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.Canceled):
+		return "La récupération des notes a été annulée."
+	case errors.Is(err, scform.ErrAccountLocked):
+		message = "Votre compte SCForm est verrouillé. Contactez votre centre de formation pour le débloquer."
+	case errors.Is(err, scform.ErrChallengeUnanswered):
+		message = "La vérification demandée par SCForm n'a pas été validée. Relancez la récupération et répondez à la vérification."
+	case errors.Is(err, scform.ErrPasswordExpired):
+		message = "Votre mot de passe SCForm a expiré. Changez-le sur SC-Connect puis réessayez."
+	case errors.Is(err, scform.ErrInvalidCredentials):
+		message = "Identifiants refusés par SCForm. Vérifiez votre nom d'utilisateur et votre mot de passe."
+	case errors.Is(err, scform.ErrTimeout):
+		message = "SCForm met trop de temps à répondre. Réessayez dans quelques minutes."
+	case errors.Is(err, scform.ErrUpstreamDown):
+		message = "SCForm semble indisponible pour le moment. Réessayez plus tard."
+	case errors.Is(err, scform.ErrBrowserUnavailable):
+		message = "Le navigateur de récupération est indisponible. Réessayez plus tard ou contactez l'administrateur."
+	case errors.Is(err, scform.ErrLayoutChanged):
+		message = "La page SCForm a changé et ne peut plus être lue. Merci de signaler le problème."
+	default:
+		message = "Une erreur inattendue est survenue lors de la récupération des notes."
+	}
+
+	if step, ok := stepNames[scform.FailedStep(err)]; ok {
+		message += " (étape : " + step + ")"
+	}
+	if id := scform.DiagnosticID(err); id != "" {
+		message += " Référence du diagnostic : " + id + "."
+	}
+	return message
+}
@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"sort"
 	"strings"
@@ -23,16 +25,39 @@ var (
 	tempDataMux     sync.RWMutex
 )
 
+// gradeStatusLabels are the names shown for the grades without a mark
+var gradeStatusLabels = map[scform.GradeStatus]string{
+	scform.GradeAbsent:    "Abs",
+	scform.GradeExempt:    "Disp",
+	scform.GradeNotGraded: "NN",
+	scform.GradePending:   "En attente",
+}
+
 // GradeHandler holds the state and methods for handling grade-related requests
 type GradeHandler struct {
 	sessionManager *session.Manager
+	source         scform.GradeSource
+	retryPolicy    *scform.RetryPolicy
+	queue          *ScrapeQueue
+	calendars      *CalendarStore
+	documents      *DocumentStore
 }
 
-// NewGradeHandler creates a new instance of GradeHandler
-func NewGradeHandler(sessionManager *session.Manager) *GradeHandler {
+// NewGradeHandler creates a new instance of GradeHandler using the grade source selected in the environment
+func NewGradeHandler(sessionManager *session.Manager) (*GradeHandler, error) {
+	source, err := scform.NewGradeSourceFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create grade source: %v", err)
+	}
+
 	return &GradeHandler{
 		sessionManager: sessionManager,
-	}
+		source:         source,
+		retryPolicy:    scform.RetryPolicyFromEnv(),
+		queue:          NewScrapeQueueFromEnv(),
+		calendars:      NewCalendarStoreFromEnv(),
+		documents:      NewDocumentStoreFromEnv(),
+	}, nil
 }
 
 // getSessionID helper function to get session ID from Fiber context
@@ -52,7 +77,7 @@ func (h *GradeHandler) getCurrentStudent(c *fiber.Ctx) *scform.Student {
 	if student, exists := tempStudentData[sessionID]; exists {
 		tempDataMux.RUnlock()
 		// Move from temp storage to session storage
-		h.setCurrentStudent(c, student)
+		h.setCurrentStudent(c, student, true)
 		// Remove from temp storage
 		tempDataMux.Lock()
 		delete(tempStudentData, sessionID)
@@ -86,8 +111,25 @@ func (h *GradeHandler) getCurrentStudent(c *fiber.Ctx) *scform.Student {
 	return nil
 }
 
-// setCurrentStudent stores the current student in session
-func (h *GradeHandler) setCurrentStudent(c *fiber.Ctx, student *scform.Student) error {
+// getStudentView returns the current student, limited to the period named by the "period" query
+// parameter when there is one. The student is nil without data, and an error is returned for an unknown period.
+func (h *GradeHandler) getStudentView(c *fiber.Ctx) (*scform.Student, error) {
+	student := h.getCurrentStudent(c)
+	period := c.Query("period")
+	if student == nil || period == "" {
+		return student, nil
+	}
+
+	view := student.ForPeriod(period)
+	if view == nil {
+		return nil, fmt.Errorf("unknown period %q", period)
+	}
+	return view, nil
+}
+
+// setCurrentStudent stores the current student in session. Only scraped data refreshes the calendar
+// subscription of the session, an imported file could hold anyone's timetable.
+func (h *GradeHandler) setCurrentStudent(c *fiber.Ctx, student *scform.Student, scraped bool) error {
 	sess, err := h.sessionManager.Store.Get(c)
 	if err != nil {
 		return fmt.Errorf("failed to get session: %v", err)
@@ -100,6 +142,12 @@ func (h *GradeHandler) setCurrentStudent(c *fiber.Ctx, student *scform.Student)
 	}
 
 	sess.Set("currentStudent", studentBytes)
+
+	// Calendar apps subscribed from this session get the new timetable
+	if scraped {
+		h.refreshSubscription(sess, student)
+	}
+
 	return sess.Save()
 }
 
@@ -159,52 +207,43 @@ func (h *GradeHandler) HandleGrades(c *fiber.Ctx) error {
 		scformURL = os.Getenv("SCFORM_URL")
 	}
 
-	// Create a channel for progress updates
-	progressChan := make(chan scform.ProgressUpdate)
+	creds := scform.Credentials{
+		URL:      scformURL,
+		Username: username,
+		Password: password,
+		// Challenges shown during the login are relayed to the pages of the session
+		Challenges: func(ctx context.Context, challenge scform.Challenge) (string, error) {
+			return relayChallenge(ctx, sessionID, challenge)
+		},
+	}
 
-	// Start a goroutine to get grades and broadcast progress
-	go func() {
-		defer close(progressChan)
+	// The retry budget is shared by every retrieval against the same SCForm host
+	host := scformURL
+	if u, err := url.Parse(scformURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
 
-		// Start a goroutine to handle progress updates for this specific session
-		go func() {
-			for progress := range progressChan {
-				BroadcastProgressToSession(sessionID, progress)
+	// Queue the retrieval, replacing any retrieval already running or waiting for this session
+	retrievalID := h.queue.Submit(sessionID, func(ctx context.Context, id string) {
+		// Progress updates are broadcast to this specific session, with the retrieval they belong to
+		send := func(update scform.ProgressUpdate) {
+			update.Retrieval = id
+			BroadcastProgressToSession(sessionID, update)
+		}
+		// Updates of the source also carry the attempt they belong to
+		progress := func(attempt int) scform.ProgressSink {
+			return func(update scform.ProgressUpdate) {
+				update.Attempt = attempt
+				send(update)
 			}
-		}()
+		}
 
-		// Retry logic with maximum 3 attempts
-		maxRetries := 3
+		// Retry according to the policy, which fails fast on errors that retrying cannot fix
 		var student *scform.Student
 		var err error
 
-		for attempt := 1; attempt <= maxRetries; attempt++ {
-			func() {
-				// Add panic recovery for each attempt
-				defer func() {
-					if r := recover(); r != nil {
-						log.Printf("Panic in grade retrieval goroutine (attempt %d/%d) for session %s: %v", attempt, maxRetries, sessionID, r)
-
-						if attempt < maxRetries {
-							log.Printf("Retrying in 2 seconds... (attempt %d/%d) for session %s", attempt+1, maxRetries, sessionID)
-							BroadcastProgressToSession(sessionID, map[string]interface{}{
-								"status":   "retrying",
-								"message":  fmt.Sprintf("Attempt %d failed, retrying... (Error: %v)", attempt, r),
-								"progress": float64(attempt) / float64(maxRetries),
-							})
-							time.Sleep(2 * time.Second)
-						} else {
-							BroadcastProgressToSession(sessionID, map[string]interface{}{
-								"status":   "error",
-								"message":  fmt.Sprintf("All %d attempts failed. Last error: %v", maxRetries, r),
-								"progress": 1.0,
-							})
-						}
-					}
-				}()
-
-				student, err = scform.GetStudentGrades(scformURL, username, password, progressChan)
-			}()
+		for attempt := 1; ; attempt++ {
+			student, err = h.source.Fetch(ctx, creds, progress(attempt))
 
 			// If we got a student successfully, break out of retry loop
 			if student != nil && err == nil {
@@ -212,50 +251,123 @@ func (h *GradeHandler) HandleGrades(c *fiber.Ctx) error {
 				break
 			}
 
-			// If this is not the last attempt, log and retry
-			if attempt < maxRetries {
-				log.Printf("Error getting grades (attempt %d/%d) for session %s: %v", attempt, maxRetries, sessionID, err)
-				log.Printf("Retrying in 2 seconds... (attempt %d/%d) for session %s", attempt+1, maxRetries, sessionID)
-				BroadcastProgressToSession(sessionID, map[string]interface{}{
-					"status":   "retrying",
-					"message":  fmt.Sprintf("Attempt %d failed, retrying... (Error: %v)", attempt, err),
-					"progress": float64(attempt) / float64(maxRetries),
+			// Stop right away if the retrieval was cancelled
+			if ctx.Err() != nil {
+				break
+			}
+
+			decision := h.retryPolicy.Decide(host, attempt, err)
+			log.Printf("Error getting grades (attempt %d) for session %s: %v, retry: %t (%s)", attempt, sessionID, err, decision.Retry, decision.Reason)
+
+			if !decision.Retry {
+				send(scform.ProgressUpdate{
+					Status:   "not_retrying",
+					Message:  fmt.Sprintf("Attempt %d failed, not retrying (%s)", attempt, decision.Reason),
+					Progress: 1.0,
+					Step:     scform.FailedStep(err),
+					Attempt:  attempt,
+					Reason:   decision.Reason,
 				})
-				time.Sleep(2 * time.Second)
+				break
+			}
+
+			log.Printf("Retrying in %s... (attempt %d) for session %s", decision.Delay, attempt+1, sessionID)
+			send(scform.ProgressUpdate{
+				Status:   "retrying",
+				Message:  fmt.Sprintf("Attempt %d failed, retrying in %.0fs (%s)", attempt, decision.Delay.Seconds(), decision.Reason),
+				Progress: 0.0,
+				Step:     scform.FailedStep(err),
+				Attempt:  attempt,
+				Reason:   decision.Reason,
+				Delay:    decision.Delay.Seconds(),
+			})
+
+			select {
+			case <-time.After(decision.Delay):
+			case <-ctx.Done():
 			}
 		}
 
+		// A retrieval replaced by a newer one of the session leaves the page to the newer one
+		if replaced(ctx) {
+			log.Printf("Grade retrieval replaced for session %s", sessionID)
+			return
+		}
+
+		// A cancelled retrieval is reported as such, whatever the last attempt returned
+		if ctx.Err() != nil {
+			log.Printf("Grade retrieval cancelled for session %s", sessionID)
+			send(scform.ProgressUpdate{
+				Status:   "cancelled",
+				Message:  "Grade retrieval cancelled",
+				Progress: 1.0,
+			})
+			return
+		}
+
 		// Check final result
 		if err != nil || student == nil {
-			log.Printf("All %d attempts failed for session %s. Final error: %v", maxRetries, sessionID, err)
-			BroadcastProgressToSession(sessionID, map[string]interface{}{
-				"status":   "error",
-				"message":  fmt.Sprintf("All %d attempts failed. Final error: %v", maxRetries, err),
-				"progress": 1.0,
+			log.Printf("Grade retrieval failed for session %s. Final error: %v", sessionID, err)
+			send(scform.ProgressUpdate{
+				Status:   "error",
+				Message:  ErrorMessage(err),
+				Progress: 1.0,
+				Step:     scform.FailedStep(err),
 			})
 			return
 		}
 
+		// The session keeps the document list, their files go to the document store
+		h.storeDocuments(student)
+
 		// Store student data in temporary storage (will be moved to session on next request)
 		h.setTempStudentData(sessionID, student)
 
-		BroadcastProgressToSession(sessionID, map[string]interface{}{
-			"status":   "success",
-			"message":  "Grades retrieved successfully",
-			"progress": 1.0,
+		send(scform.ProgressUpdate{
+			Status:   "success",
+			Message:  "Grades retrieved successfully",
+			Progress: 1.0,
 		})
-	}()
+	})
 
 	// Return success response immediately
 	return c.JSON(fiber.Map{
-		"status":  "processing",
-		"message": "Grade retrieval started",
+		"status":    "processing",
+		"message":   "Grade retrieval queued",
+		"retrieval": retrievalID,
+	})
+}
+
+// HandleCancelGrades cancels the grade retrieval running for the current session
+func (h *GradeHandler) HandleCancelGrades(c *fiber.Ctx) error {
+	sessionID := h.getSessionID(c)
+	if sessionID == "" {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to get session ID",
+		})
+	}
+
+	if !CancelRetrieval(sessionID) {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "No grade retrieval in progress",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "cancelling",
+		"message": "Grade retrieval cancellation requested",
 	})
 }
 
 // HandleSearch handles the search and sort functionality
 func (h *GradeHandler) HandleSearch(c *fiber.Ctx) error {
-	if h.getCurrentStudent(c) == nil {
+	student, err := h.getStudentView(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if student == nil {
 		return c.Status(400).JSON(fiber.Map{
 			"error": "No grades data available",
 		})
@@ -267,13 +379,14 @@ func (h *GradeHandler) HandleSearch(c *fiber.Ctx) error {
 
 	// Create a copy of the student data
 	filteredStudent := &scform.Student{
-		Name:         h.getCurrentStudent(c).Name,
-		TotalAverage: h.getCurrentStudent(c).TotalAverage,
+		Name:         student.Name,
+		Profile:      student.Profile,
+		TotalAverage: student.TotalAverage,
 		Grades:       []scform.Course{},
 	}
 
 	// Filter courses
-	for _, course := range h.getCurrentStudent(c).Grades {
+	for _, course := range student.Grades {
 		if query == "" || strings.Contains(strings.ToLower(course.Name), query) {
 			// Create a copy of the course
 			filteredCourse := scform.Course{
@@ -319,6 +432,7 @@ func (h *GradeHandler) HandleSearch(c *fiber.Ctx) error {
 
 	return c.Render("partials/grades", fiber.Map{
 		"Student": filteredStudent,
+		"Scale":   scform.GradeScale(),
 		"SortBy":  sortBy,
 		"SortDir": sortDir,
 	}, "")
@@ -326,17 +440,24 @@ func (h *GradeHandler) HandleSearch(c *fiber.Ctx) error {
 
 // HandlePrint renders the print-friendly version of the grades
 func (h *GradeHandler) HandlePrint(c *fiber.Ctx) error {
-	if h.getCurrentStudent(c) == nil {
+	student, err := h.getStudentView(c)
+	if err != nil || student == nil {
 		return c.Redirect("/")
 	}
 
-	// Get current year for the academic year display
-	currentYear := time.Now().Year()
-	academicYear := fmt.Sprintf("%d-%d", currentYear-1, currentYear)
+	// Show the school year of the profile, or guess it from the current year
+	academicYear := student.Profile.SchoolYear
+	if academicYear == "" {
+		currentYear := time.Now().Year()
+		academicYear = fmt.Sprintf("%d-%d", currentYear-1, currentYear)
+	}
 
 	return c.Render("print", fiber.Map{
-		"Student":      h.getCurrentStudent(c),
+		"Student":      student,
+		"Period":       c.Query("period"),
 		"AcademicYear": academicYear,
+		"Scale":        scform.GradeScale(),
+		"StatusLabels": gradeStatusLabels,
 	}, "layouts/no_partial")
 }
 
@@ -354,9 +475,10 @@ func (h *GradeHandler) HandlePrintDemo(c *fiber.Ctx) error {
 		})
 		for j := 0; j < 3; j++ {
 			student.Grades[i].Grades = append(student.Grades[i].Grades, scform.Grade{
-				Title: fmt.Sprintf("Midterm %d", j),
-				Value: float64(j),
-				Date:  time.Now(),
+				Title:  fmt.Sprintf("Midterm %d", j),
+				Value:  float64(j),
+				Date:   time.Now(),
+				Status: scform.GradeGraded,
 			})
 		}
 	}
@@ -368,18 +490,26 @@ func (h *GradeHandler) HandlePrintDemo(c *fiber.Ctx) error {
 	return c.Render("print", fiber.Map{
 		"Student":      student,
 		"AcademicYear": academicYear,
+		"Scale":        scform.GradeScale(),
+		"StatusLabels": gradeStatusLabels,
 	}, "layouts/no_partial")
 }
 
 // HandleExport handles the export of grades to JSON
 func (h *GradeHandler) HandleExport(c *fiber.Ctx) error {
-	if h.getCurrentStudent(c) == nil {
+	student, err := h.getStudentView(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if student == nil {
 		return c.Status(400).JSON(fiber.Map{
 			"error": "No grades data available",
 		})
 	}
 
-	jsonData, err := scform.ExportToJSON(h.getCurrentStudent(c))
+	jsonData, err := scform.ExportToJSON(student)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"error": err.Error(),
@@ -393,13 +523,19 @@ func (h *GradeHandler) HandleExport(c *fiber.Ctx) error {
 
 // HandleExcelExport handles the export of grades to Excel
 func (h *GradeHandler) HandleExcelExport(c *fiber.Ctx) error {
-	if h.getCurrentStudent(c) == nil {
+	student, err := h.getStudentView(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if student == nil {
 		return c.Status(400).JSON(fiber.Map{
 			"error": "No grades data available",
 		})
 	}
 
-	f, err := scform.ExportToExcel(h.getCurrentStudent(c))
+	f, err := scform.ExportToExcel(student)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"error": err.Error(),
@@ -467,7 +603,7 @@ func (h *GradeHandler) HandleImport(c *fiber.Ctx) error {
 	student.CalculateTotalAverage()
 
 	// Set as current student
-	h.setCurrentStudent(c, &student)
+	h.setCurrentStudent(c, &student, false)
 
 	// Log successful import
 	log.Printf("Successfully imported grades for student: %s", student.Name)
@@ -482,7 +618,13 @@ func (h *GradeHandler) HandleImport(c *fiber.Ctx) error {
 
 // HandleGradesAPI returns grades data as JSON for the Excel-like table
 func (h *GradeHandler) HandleGradesAPI(c *fiber.Ctx) error {
-	if h.getCurrentStudent(c) == nil {
+	student, err := h.getStudentView(c)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if student == nil {
 		return c.Status(400).JSON(fiber.Map{
 			"error": "No grades data available",
 		})
@@ -495,7 +637,7 @@ func (h *GradeHandler) HandleGradesAPI(c *fiber.Ctx) error {
 	// Create a grouped structure by course
 	var groupedCourses []map[string]interface{}
 
-	for _, course := range h.getCurrentStudent(c).Grades {
+	for _, course := range student.Grades {
 		if query == "" || strings.Contains(strings.ToLower(course.Name), query) {
 			// Create course object with its grades
 			courseData := map[string]interface{}{
@@ -511,6 +653,9 @@ func (h *GradeHandler) HandleGradesAPI(c *fiber.Ctx) error {
 					"title":         grade.Title,
 					"value":         grade.Value,
 					"outOf":         grade.OutOf,
+					"normalized":    grade.Normalized(),
+					"status":        grade.Status,
+					"statusLabel":   gradeStatusLabels[grade.Status],
 					"coefficient":   grade.Coefficient,
 					"date":          grade.Date.Format("2006-01-02"),
 					"dateFormatted": grade.Date.Format("02/01/06"),
@@ -565,12 +710,33 @@ func (h *GradeHandler) HandleGradesAPI(c *fiber.Ctx) error {
 		totalGrades += course["gradeCount"].(int)
 	}
 
+	// List the periods to switch between, the view of a period has none itself
+	periods := []map[string]interface{}{}
+	for _, period := range h.getCurrentStudent(c).Periods {
+		periodData := map[string]interface{}{
+			"name":    period.Name,
+			"average": period.Average,
+		}
+		if !period.Start.IsZero() && !period.End.IsZero() {
+			periodData["start"] = period.Start.Format("2006-01-02")
+			periodData["end"] = period.End.Format("2006-01-02")
+		}
+		periods = append(periods, periodData)
+	}
+
 	return c.JSON(fiber.Map{
 		"student": map[string]interface{}{
-			"name":         h.getCurrentStudent(c).Name,
-			"totalAverage": h.getCurrentStudent(c).TotalAverage,
+			"name":         student.Name,
+			"formation":    student.Profile.Formation,
+			"class":        student.Profile.Class,
+			"centre":       student.Profile.Centre,
+			"schoolYear":   student.Profile.SchoolYear,
+			"totalAverage": student.TotalAverage,
 		},
 		"courses": groupedCourses,
 		"total":   totalGrades,
+		"scale":   scform.GradeScale(),
+		"period":  c.Query("period"),
+		"periods": periods,
 	})
 }
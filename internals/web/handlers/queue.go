@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"scrapping/internals/scform"
+)
+
+const (
+	// defaultQueueWorkers is the number of retrievals run at once when SCFORM_QUEUE_WORKERS is unset
+	defaultQueueWorkers = 4
+	// defaultJobDuration seeds the wait estimate until real retrievals have been timed
+	defaultJobDuration = 30 * time.Second
+)
+
+// scrapeJob is a grade retrieval waiting in, or taken from, the ScrapeQueue
+type scrapeJob struct {
+	sessionID string
+	id        string // Retrieval ID, sent with the updates about the job
+	ctx       context.Context
+	done      func()
+	run       func(ctx context.Context, id string)
+	stop      func() bool // Stops watching ctx for a cancellation while queued, false if drop already has the job
+}
+
+// ScrapeQueue runs grade retrievals with a fixed number of workers. Waiting sessions are told
+// their position and estimated wait, and a session resubmitting replaces its queued job.
+//
+// Whoever stops a job owns it: a worker or Submit if job.stop returns true, drop otherwise.
+// Its owner calls job.done, and only drop ever tells the session its job was cancelled.
+// drop leaves replaced jobs to Submit, so a replaced job never gets a cancelled update.
+type ScrapeQueue struct {
+	workers int
+	notify  func(sessionID string, update scform.ProgressUpdate) // Sends an update to a session
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	pending     []*scrapeJob
+	avgDuration time.Duration
+}
+
+// NewScrapeQueue creates a queue and starts its workers
+func NewScrapeQueue(workers int) *ScrapeQueue {
+	return newScrapeQueue(workers, BroadcastProgressToSession)
+}
+
+// newScrapeQueue creates a queue sending its updates through notify
+func newScrapeQueue(workers int, notify func(sessionID string, update scform.ProgressUpdate)) *ScrapeQueue {
+	if workers <= 0 {
+		workers = 1
+	}
+
+	q := &ScrapeQueue{
+		workers:     workers,
+		notify:      notify,
+		avgDuration: defaultJobDuration,
+	}
+	q.cond = sync.NewCond(&q.mu)
+
+	for i := 0; i < workers; i++ {
+		go q.worker()
+	}
+	return q
+}
+
+// NewScrapeQueueFromEnv creates a queue with SCFORM_QUEUE_WORKERS workers
+func NewScrapeQueueFromEnv() *ScrapeQueue {
+	workers := defaultQueueWorkers
+	if value := os.Getenv("SCFORM_QUEUE_WORKERS"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			workers = n
+		} else {
+			log.Printf("Invalid SCFORM_QUEUE_WORKERS %q, using %d", value, workers)
+		}
+	}
+	return NewScrapeQueue(workers)
+}
+
+// Submit queues a retrieval for the session and returns its ID. Any retrieval already running for
+// it is cancelled, and a retrieval still waiting is replaced in place so the session keeps its position.
+func (q *ScrapeQueue) Submit(sessionID string, run func(ctx context.Context, id string)) string {
+	// The previous retrieval is cancelled as replaced, so drop leaves its queued job for the swap below
+	ctx, id, done := startRetrieval(sessionID)
+	job := &scrapeJob{
+		sessionID: sessionID,
+		id:        id,
+		ctx:       ctx,
+		done:      done,
+		run:       run,
+	}
+
+	q.mu.Lock()
+	if replaced(ctx) {
+		// A concurrent submit of the session already replaced this one, it must not take its place
+		q.mu.Unlock()
+		done()
+		return id
+	}
+	job.stop = context.AfterFunc(ctx, func() { q.drop(job) })
+
+	swapped := false
+	for i, queued := range q.pending {
+		if queued.sessionID == sessionID {
+			log.Printf("Replacing queued grade retrieval for session %s", sessionID)
+			if queued.stop() {
+				queued.done()
+			}
+			q.pending[i] = job
+			swapped = true
+			break
+		}
+	}
+	if !swapped {
+		q.pending = append(q.pending, job)
+	}
+
+	q.cond.Signal()
+	q.mu.Unlock()
+
+	q.broadcastPositions()
+	return id
+}
+
+// worker runs queued retrievals one after the other
+func (q *ScrapeQueue) worker() {
+	for {
+		q.mu.Lock()
+		for len(q.pending) == 0 {
+			q.cond.Wait()
+		}
+		job := q.pending[0]
+		q.pending = q.pending[1:]
+		// A job already cancelled belongs to drop and is simply skipped
+		watching := job.stop()
+		q.mu.Unlock()
+
+		if !watching {
+			continue
+		}
+
+		q.broadcastPositions()
+
+		start := time.Now()
+		q.runJob(job)
+		q.recordDuration(time.Since(start))
+	}
+}
+
+// runJob runs a retrieval, turning a panic into an error for its session rather than a server crash
+func (q *ScrapeQueue) runJob(job *scrapeJob) {
+	defer job.done()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic in grade retrieval for session %s: %v\n%s", job.sessionID, r, debug.Stack())
+			q.notify(job.sessionID, scform.ProgressUpdate{
+				Status:    "error",
+				Message:   ErrorMessage(fmt.Errorf("panic: %v", r)),
+				Progress:  1.0,
+				Retrieval: job.id,
+			})
+		}
+	}()
+
+	job.run(job.ctx, job.id)
+}
+
+// drop handles a job cancelled before a worker took it. A cancelled job is removed and its
+// session told, a replaced one is left in place for Submit to swap with its replacement.
+func (q *ScrapeQueue) drop(job *scrapeJob) {
+	job.done()
+	if replaced(job.ctx) {
+		return
+	}
+
+	q.mu.Lock()
+	for i, queued := range q.pending {
+		if queued == job {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			break
+		}
+	}
+	q.mu.Unlock()
+
+	log.Printf("Queued grade retrieval cancelled for session %s", job.sessionID)
+	q.notify(job.sessionID, scform.ProgressUpdate{
+		Status:    "cancelled",
+		Message:   "Grade retrieval cancelled",
+		Progress:  1.0,
+		Retrieval: job.id,
+	})
+	q.broadcastPositions()
+}
+
+// recordDuration folds a retrieval duration into the moving average used for wait estimates
+func (q *ScrapeQueue) recordDuration(d time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.avgDuration = time.Duration(0.8*float64(q.avgDuration) + 0.2*float64(d))
+}
+
+// broadcastPositions tells every waiting session its position and estimated wait
+func (q *ScrapeQueue) broadcastPositions() {
+	q.mu.Lock()
+	jobs := slices.Clone(q.pending)
+	avg := q.avgDuration
+	q.mu.Unlock()
+
+	total := len(jobs)
+	for i, job := range jobs {
+		position := i + 1
+		// Jobs ahead are served workers at a time, plus the batch currently running
+		wait := time.Duration(math.Ceil(float64(position)/float64(q.workers))) * avg
+
+		q.notify(job.sessionID, scform.ProgressUpdate{
+			Status:      "queued",
+			Message:     fmt.Sprintf("Queued: position %d of %d, about %.0fs to wait", position, total, wait.Seconds()),
+			Progress:    0.0,
+			ETA:         wait.Seconds(),
+			Position:    position,
+			QueueLength: total,
+			Retrieval:   job.id,
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"scrapping/internals/scform"
+)
+
+// recorder collects the updates a queue sends
+type recorder struct {
+	mu      sync.Mutex
+	updates []scform.ProgressUpdate
+}
+
+func (r *recorder) notify(sessionID string, update scform.ProgressUpdate) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.updates = append(r.updates, update)
+}
+
+// statuses returns the statuses sent about the retrieval
+func (r *recorder) statuses(id string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var statuses []string
+	for _, update := range r.updates {
+		if update.Retrieval == id {
+			statuses = append(statuses, update.Status)
+		}
+	}
+	return statuses
+}
+
+// lastPosition returns the last queue position sent about the retrieval
+func (r *recorder) lastPosition(id string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	position := 0
+	for _, update := range r.updates {
+		if update.Retrieval == id && update.Status == "queued" {
+			position = update.Position
+		}
+	}
+	return position
+}
+
+// blockingRun returns a run function that reports it started and waits for release or its cancellation.
+// Its context is sent on ended once it returns.
+func blockingRun(started chan<- string, release <-chan struct{}, ended chan<- context.Context) func(context.Context, string) {
+	return func(ctx context.Context, id string) {
+		started <- id
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		if ended != nil {
+			ended <- ctx
+		}
+	}
+}
+
+func receive[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		panic("unreachable")
+	}
+}
+
+func TestScrapeQueueReplacesQueuedJob(t *testing.T) {
+	rec := &recorder{}
+	q := newScrapeQueue(1, rec.notify)
+	started := make(chan string, 4)
+	release := make(chan struct{})
+
+	// Keep the only worker busy so the next jobs wait
+	q.Submit("replace-busy", blockingRun(started, release, nil))
+	receive(t, started, "the busy job")
+
+	first := q.Submit("replace-user", blockingRun(started, release, nil))
+	other := q.Submit("replace-other", blockingRun(started, release, nil))
+	if got := rec.lastPosition(first); got != 1 {
+		t.Fatalf("first job at position %d, want 1", got)
+	}
+
+	second := q.Submit("replace-user", blockingRun(started, release, nil))
+	if got := rec.lastPosition(second); got != 1 {
+		t.Errorf("replacement at position %d, want 1 (the replaced job's)", got)
+	}
+	if got := rec.lastPosition(other); got != 2 {
+		t.Errorf("other job at position %d, want 2", got)
+	}
+
+	close(release)
+	for _, want := range []string{second, other} {
+		if got := receive(t, started, "a queued job"); got != want {
+			t.Fatalf("started %s, want %s", got, want)
+		}
+	}
+	select {
+	case id := <-started:
+		t.Errorf("unexpected job %s started", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if statuses := rec.statuses(first); slices.Contains(statuses, "cancelled") {
+		t.Errorf("replaced job was reported cancelled: %v", statuses)
+	}
+}
+
+func TestScrapeQueueDropsCancelledJob(t *testing.T) {
+	rec := &recorder{}
+	q := newScrapeQueue(1, rec.notify)
+	started := make(chan string, 4)
+	release := make(chan struct{})
+	defer close(release)
+
+	q.Submit("drop-busy", blockingRun(started, release, nil))
+	receive(t, started, "the busy job")
+
+	cancelled := q.Submit("drop-user", blockingRun(started, release, nil))
+	other := q.Submit("drop-other", blockingRun(started, release, nil))
+
+	if !CancelRetrieval("drop-user") {
+		t.Fatal("no retrieval to cancel")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !slices.Contains(rec.statuses(cancelled), "cancelled") {
+		if time.Now().After(deadline) {
+			t.Fatalf("cancelled job not reported, got %v", rec.statuses(cancelled))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	for rec.lastPosition(other) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("other job still at position %d", rec.lastPosition(other))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	q.mu.Lock()
+	pending := len(q.pending)
+	q.mu.Unlock()
+	if pending != 1 {
+		t.Errorf("%d jobs pending, want 1", pending)
+	}
+}
+
+func TestScrapeQueueReplacesRunningJob(t *testing.T) {
+	rec := &recorder{}
+	q := newScrapeQueue(2, rec.notify)
+	started := make(chan string, 4)
+	ended := make(chan context.Context, 4)
+	release := make(chan struct{})
+	defer close(release)
+
+	first := q.Submit("running-user", blockingRun(started, release, ended))
+	receive(t, started, "the first job")
+
+	second := q.Submit("running-user", blockingRun(started, release, ended))
+	ctx := receive(t, ended, "the first job to stop")
+	if !replaced(ctx) {
+		t.Errorf("first job stopped with %v, want replaced", context.Cause(ctx))
+	}
+	if got := receive(t, started, "the second job"); got != second {
+		t.Errorf("started %s, want %s", got, second)
+	}
+
+	if statuses := rec.statuses(first); slices.Contains(statuses, "cancelled") {
+		t.Errorf("replaced job was reported cancelled: %v", statuses)
+	}
+}
+
+func TestScrapeQueueRecoversPanics(t *testing.T) {
+	rec := &recorder{}
+	q := newScrapeQueue(1, rec.notify)
+	started := make(chan string, 1)
+
+	id := q.Submit("panic-user", func(ctx context.Context, id string) {
+		panic("boom")
+	})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !slices.Contains(rec.statuses(id), "error") {
+		if time.Now().After(deadline) {
+			t.Fatalf("panic not reported, got %v", rec.statuses(id))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The worker survived and the retrieval was released
+	next := q.Submit("panic-user", func(ctx context.Context, id string) { started <- id })
+	if got := receive(t, started, "the next job"); got != next {
+		t.Errorf("started %s, want %s", got, next)
+	}
+}
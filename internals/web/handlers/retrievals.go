@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"scrapping/internals/utils"
+)
+
+// disconnectGracePeriod is how long a retrieval survives once the last WebSocket
+// of its session is gone, so a page reload or a reconnect does not cancel it
+const disconnectGracePeriod = 15 * time.Second
+
+// errRetrievalReplaced is the cancellation cause of a retrieval replaced by a newer one of its session
+var errRetrievalReplaced = errors.New("replaced by a newer retrieval")
+
+// retrieval is a grade retrieval in flight for a session
+type retrieval struct {
+	cancel context.CancelCauseFunc
+}
+
+var (
+	// retrievals holds the in-flight grade retrieval of each session
+	retrievals    = make(map[string]*retrieval)
+	retrievalsMux sync.Mutex
+
+	// baseCtx is the parent of every retrieval context, cancelled on shutdown
+	baseCtx, cancelBaseCtx = context.WithCancel(context.Background())
+)
+
+// startRetrieval cancels any retrieval running for the session and registers a new one, returning
+// its context and the ID its progress updates carry so the page can tell its retrieval apart.
+// The previous retrieval is cancelled with errRetrievalReplaced, see replaced. The returned
+// function must be called once the retrieval is over.
+func startRetrieval(sessionID string) (context.Context, string, func()) {
+	ctx, cancel := context.WithCancelCause(baseCtx)
+	id, err := utils.CreateShortLink(16)
+	if err != nil {
+		// The ID only tells retrievals apart in the page, a fixed one still works for a single tab
+		log.Printf("Failed to create retrieval ID for session %s: %v", sessionID, err)
+	}
+	r := &retrieval{cancel: cancel}
+
+	retrievalsMux.Lock()
+	if previous, exists := retrievals[sessionID]; exists {
+		log.Printf("Cancelling previous grade retrieval for session %s", sessionID)
+		previous.cancel(errRetrievalReplaced)
+	}
+	retrievals[sessionID] = r
+	retrievalsMux.Unlock()
+
+	return ctx, id, func() {
+		retrievalsMux.Lock()
+		if retrievals[sessionID] == r {
+			delete(retrievals, sessionID)
+		}
+		retrievalsMux.Unlock()
+		cancel(nil)
+	}
+}
+
+// replaced reports whether the retrieval of ctx was cancelled because its session started a
+// newer one. Its session is then told about the newer one only, never that it was cancelled.
+func replaced(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errRetrievalReplaced)
+}
+
+// CancelRetrieval cancels the retrieval running for the session, reporting whether there was one
+func CancelRetrieval(sessionID string) bool {
+	retrievalsMux.Lock()
+	defer retrievalsMux.Unlock()
+
+	r, exists := retrievals[sessionID]
+	if !exists {
+		return false
+	}
+	log.Printf("Cancelling grade retrieval for session %s", sessionID)
+	r.cancel(nil)
+	delete(retrievals, sessionID)
+	return true
+}
+
+// CancelAllRetrievals cancels every retrieval in flight, used when the server shuts down
+func CancelAllRetrievals() {
+	retrievalsMux.Lock()
+	defer retrievalsMux.Unlock()
+
+	cancelBaseCtx()
+	for sessionID := range retrievals {
+		delete(retrievals, sessionID)
+	}
+}
+
+// cancelRetrievalIfAbandoned cancels the session retrieval if no WebSocket reconnects within the grace period
+func cancelRetrievalIfAbandoned(sessionID string) {
+	time.AfterFunc(disconnectGracePeriod, func() {
+		connectionsMux.Lock()
+		connected := len(connections[sessionID]) > 0
+		connectionsMux.Unlock()
+
+		if !connected && CancelRetrieval(sessionID) {
+			log.Printf("No WebSocket left for session %s, grade retrieval abandoned", sessionID)
+		}
+	})
+}
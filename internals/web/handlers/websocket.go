@@ -6,21 +6,19 @@ import (
 	"sync"
 
 	"github.com/gofiber/contrib/websocket"
+
+	"scrapping/internals/scform"
 )
 
+// maxClientMessageSize bounds the messages a page sends over the WebSocket
+const maxClientMessageSize = 4096
+
 var (
 	// Connections holds WebSocket connections organized by session ID
 	connections    = make(map[string]map[*websocket.Conn]bool)
 	connectionsMux sync.Mutex
 )
 
-// ProgressUpdate represents a progress update message
-type ProgressUpdate struct {
-	Status   string  `json:"status"`
-	Message  string  `json:"message"`
-	Progress float64 `json:"progress"`
-}
-
 // WebSocketHandler handles WebSocket connections
 func WebSocketHandler(c *websocket.Conn) {
 	// Get session from the HTTP context (passed via Locals)
@@ -48,40 +46,63 @@ func WebSocketHandler(c *websocket.Conn) {
 
 	log.Printf("WebSocket connection established for session: %s", sessionIDStr)
 
+	// A page reloaded while its login waits for a challenge answer gets the challenge again
+	resendChallenge(sessionIDStr)
+
 	defer func() {
 		// Unregister connection on close
 		connectionsMux.Lock()
+		lastConnection := false
 		if connections[sessionIDStr] != nil {
 			delete(connections[sessionIDStr], c)
 			// Clean up empty session maps
 			if len(connections[sessionIDStr]) == 0 {
 				delete(connections, sessionIDStr)
+				lastConnection = true
 			}
 		}
 		connectionsMux.Unlock()
+
+		// The tab was closed: stop its grade retrieval unless it comes back
+		if lastConnection {
+			cancelRetrievalIfAbandoned(sessionIDStr)
+		}
 		c.Close()
 		log.Printf("WebSocket connection closed for session: %s", sessionIDStr)
 	}()
 
-	// Keep connection alive and handle incoming messages
+	// Keep connection alive and handle incoming messages, which answer login challenges
+	c.SetReadLimit(maxClientMessageSize)
 	for {
-		_, _, err := c.ReadMessage()
+		_, data, err := c.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("error reading message for session %s: %v", sessionIDStr, err)
 			}
 			break
 		}
+
+		var msg clientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			log.Printf("Ignoring invalid message for session %s: %v", sessionIDStr, err)
+			continue
+		}
+		switch msg.Type {
+		case "challenge_answer":
+			answerChallenge(sessionIDStr, msg)
+		default:
+			log.Printf("Ignoring message of unknown type %q for session %s", msg.Type, sessionIDStr)
+		}
 	}
 }
 
 // BroadcastProgress sends a progress update to all connected clients (legacy function for backwards compatibility)
-func BroadcastProgress(update interface{}) {
+func BroadcastProgress(update scform.ProgressUpdate) {
 	BroadcastProgressToAll(update)
 }
 
 // BroadcastProgressToAll sends a progress update to all connected clients
-func BroadcastProgressToAll(update interface{}) {
+func BroadcastProgressToAll(update scform.ProgressUpdate) {
 	data, err := json.Marshal(update)
 	if err != nil {
 		log.Printf("error marshaling progress update: %v", err)
@@ -107,10 +128,15 @@ func BroadcastProgressToAll(update interface{}) {
 }
 
 // BroadcastProgressToSession sends a progress update to all connections of a specific session
-func BroadcastProgressToSession(sessionID string, update interface{}) {
-	data, err := json.Marshal(update)
+func BroadcastProgressToSession(sessionID string, update scform.ProgressUpdate) {
+	sendToSession(sessionID, update)
+}
+
+// sendToSession sends a message to all connections of a specific session
+func sendToSession(sessionID string, message interface{}) {
+	data, err := json.Marshal(message)
 	if err != nil {
-		log.Printf("error marshaling progress update: %v", err)
+		log.Printf("error marshaling message for session %s: %v", sessionID, err)
 		return
 	}
 
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AdminOnly restricts a route to requests carrying the ADMIN_TOKEN as a bearer token, never in the
+// URL where it would end up in logs and browser history. Without ADMIN_TOKEN the routes it guards are disabled.
+func AdminOnly() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		adminToken := os.Getenv("ADMIN_TOKEN")
+		if adminToken == "" {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Not found",
+			})
+		}
+
+		token, found := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			return c.Status(401).JSON(fiber.Map{
+				"error": "Unauthorized",
+			})
+		}
+
+		return c.Next()
+	}
+}
@@ -2,6 +2,7 @@ package router
 
 import (
 	"scrapping/internals/web/handlers"
+	"scrapping/internals/web/middleware"
 	"scrapping/internals/web/session"
 
 	"github.com/gofiber/contrib/websocket"
@@ -9,9 +10,12 @@ import (
 )
 
 // SetupRoutes configures all the routes for the application
-func SetupRoutes(app *fiber.App, sessionManager *session.Manager) {
+func SetupRoutes(app *fiber.App, sessionManager *session.Manager) error {
 	// Create handlers
-	gradeHandler := handlers.NewGradeHandler(sessionManager)
+	gradeHandler, err := handlers.NewGradeHandler(sessionManager)
+	if err != nil {
+		return err
+	}
 
 	// WebSocket middleware
 	app.Use("/ws", func(c *fiber.Ctx) error {
@@ -50,11 +54,27 @@ func SetupRoutes(app *fiber.App, sessionManager *session.Manager) {
 	app.Get("/", gradeHandler.HandleIndex)
 	app.Get("/about", gradeHandler.HandleAbout)
 	app.Post("/grades", gradeHandler.HandleGrades)
+	app.Post("/grades/cancel", gradeHandler.HandleCancelGrades)
 	app.Post("/import", gradeHandler.HandleImport)
 	app.Get("/search", gradeHandler.HandleSearch)
 	app.Get("/api/grades", gradeHandler.HandleGradesAPI)
+	app.Get("/absences", gradeHandler.HandleAbsences)
+	app.Get("/api/absences", gradeHandler.HandleAbsencesAPI)
+	app.Get("/documents", gradeHandler.HandleDocuments)
+	app.Get("/api/documents", gradeHandler.HandleDocumentsAPI)
+	app.Get("/documents/:id", gradeHandler.HandleDocumentDownload)
 	app.Get("/print", gradeHandler.HandlePrint)
 	app.Get("/print/demo", gradeHandler.HandlePrintDemo)
 	app.Get("/export", gradeHandler.HandleExport)
 	app.Get("/export/excel", gradeHandler.HandleExcelExport)
+	app.Get("/timetable.ics", gradeHandler.HandleTimetableICS)
+	app.Post("/api/timetable/subscription", gradeHandler.HandleCreateSubscription)
+	app.Delete("/api/timetable/subscription", gradeHandler.HandleDeleteSubscription)
+	app.Get("/calendar/:token.ics", gradeHandler.HandleCalendarFeed)
+
+	// Admin routes
+	app.Get("/api/browsers", middleware.AdminOnly(), gradeHandler.HandleBrowsers)
+	app.Get("/admin/diagnostics/:id", middleware.AdminOnly(), gradeHandler.HandleDiagnostic)
+
+	return nil
 }
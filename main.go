@@ -3,9 +3,13 @@ package main
 import (
 	"log"
 	"os"
+	"os/signal"
+	"scrapping/internals/scform"
 	"scrapping/internals/utils"
+	"scrapping/internals/web/handlers"
 	"scrapping/internals/web/router"
 	"strings"
+	"syscall"
 
 	"github.com/gofiber/template/html/v2"
 	"github.com/joho/godotenv"
@@ -37,15 +41,37 @@ func init() {
 func main() {
 	utils.InitAssets()
 
+	// Load the SCForm layout profiles, a broken profile file must not go unnoticed
+	if err := scform.LoadLayoutsFromEnv(); err != nil {
+		log.Fatal("Error loading layout profiles:", err)
+	}
+
 	engine := html.New("./views/", ".tpl")
 	engine.Reload(false)
 
 	// Initialize the router with all middleware
 	app := router.New(engine)
 
-	// Setup all routes with the session manager
-	router.SetupRoutes(app, router.SessionManager)
+	// Setup all routes with the session manager, a misconfigured grade source stops the startup
+	if err := router.SetupRoutes(app, router.SessionManager); err != nil {
+		log.Fatal("Error setting up routes:", err)
+	}
+
+	// On shutdown, stop in-flight grade retrievals so their browsers get closed
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+		<-quit
+
+		log.Println("Shutting down, cancelling grade retrievals")
+		handlers.CancelAllRetrievals()
+		if err := app.Shutdown(); err != nil {
+			log.Printf("Error shutting down server: %v", err)
+		}
+	}()
 
 	// Start server
-	log.Fatal(app.Listen(":3000"))
+	if err := app.Listen(":3000"); err != nil {
+		log.Fatal(err)
+	}
 }
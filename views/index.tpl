@@ -6,7 +6,7 @@
             <form hx-post="/grades" 
                   hx-target="#grades-container" 
                   hx-indicator="#spinner"
-                  hx-on::after-request="initWebSocket()"
+                  hx-on::after-request="initWebSocket(event)"
                   class="space-y-4">
                 
                 <div class="form-control w-full">
@@ -91,6 +91,26 @@
                     <div id="progress-bar" class="bg-primary h-2.5 rounded-full" style="width: 0%"></div>
                 </div>
                 <p id="progress-message" class="text-sm text-gray-600 mt-2 text-center"></p>
+                <ol id="progress-steps" class="hidden text-sm text-gray-600 mt-2 space-y-1"></ol>
+                <form id="challenge-form" class="hidden mt-4" onsubmit="answerChallenge(event, false)">
+                    <p id="challenge-message" class="text-sm text-gray-700 mb-2"></p>
+                    <img id="challenge-screenshot" class="w-full rounded border border-gray-300" alt="Vérification demandée par SCForm">
+                    <input type="text"
+                           id="challenge-answer"
+                           class="input input-bordered w-full mt-2"
+                           autocomplete="one-time-code"
+                           placeholder="Votre réponse">
+                    <div class="flex gap-2 mt-2">
+                        <button type="submit" class="btn btn-sm btn-primary flex-1">Valider</button>
+                        <button type="button" class="btn btn-sm btn-outline flex-1" onclick="answerChallenge(event, true)">Refuser</button>
+                    </div>
+                </form>
+                <button type="button"
+                        id="cancel-button"
+                        class="btn btn-sm btn-outline btn-error w-full mt-2"
+                        onclick="cancelGrades()">
+                    Annuler
+                </button>
             </div>
 
             <div id="spinner" class="htmx-indicator">
@@ -139,6 +159,7 @@
             <button id="download-button"
                     class="btn btn-accent hidden"
                     hx-get="/export"
+                    hx-vals='js:{period: selectedPeriod}'
                     hx-target="this"
                     hx-swap="none">
                 <svg xmlns="http://www.w3.org/2000/svg" class="h-6 w-6 mr-2" fill="none" viewBox="0 0 24 24" stroke="currentColor">
@@ -154,8 +175,23 @@
                 </svg>
                 Télécharger Excel
             </button>
+            <button id="ics-download-button"
+                    class="btn btn-info hidden"
+                    onclick="window.location.href = '/timetable.ics'">
+                <svg xmlns="http://www.w3.org/2000/svg" class="h-6 w-6 mr-2" fill="none" viewBox="0 0 24 24" stroke="currentColor">
+                    <path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M8 7V3m8 4V3m-9 8h10M5 21h14a2 2 0 002-2V7a2 2 0 00-2-2H5a2 2 0 00-2 2v12a2 2 0 002 2z" />
+                </svg>
+                Emploi du temps (.ics)
+            </button>
+            <button id="ics-subscribe-button"
+                    class="btn btn-outline hidden"
+                    onclick="subscribeCalendar()">
+                S'abonner au calendrier
+            </button>
         </div>
         <div id="grades-container" class="overflow-x-auto w-full min-h-96"></div>
+        <div id="absences-container" class="w-full"></div>
+        <div id="documents-container" class="w-full"></div>
     </div>
 </div>
 
@@ -238,6 +274,19 @@
             ws.onmessage = function(event) {
                 try {
                     const data = JSON.parse(event.data);
+
+                    // A replaced retrieval must not end the current one, nor close its socket
+                    if (data.retrieval && currentRetrieval && data.retrieval !== currentRetrieval) {
+                        return;
+                    }
+
+                    // The login waits for the user to answer a challenge
+                    if (data.status === 'challenge') {
+                        showChallenge(data);
+                        return;
+                    }
+                    hideChallenge();
+
                     const progressBar = document.getElementById('progress-bar');
                     const progressMessage = document.getElementById('progress-message');
                     
@@ -245,13 +294,23 @@
                     if (progressBar) {
                         progressBar.style.width = `${data.progress * 100}%`;
                     }
+                    renderProgressSteps(data);
                     if (progressMessage) {
-                        progressMessage.textContent = data.message;
-                        progressMessage.className = 'text-sm text-gray-600 mt-2 text-center';
+                        let message = data.message;
+                        if (data.steps && data.attempt > 1) {
+                            message = `Tentative ${data.attempt} : ${message}`;
+                        }
+                        if (data.eta > 0 && data.status !== 'queued') {
+                            message += ` (environ ${Math.ceil(data.eta)}s restantes)`;
+                        }
+                        progressMessage.textContent = message;
+                        progressMessage.className = data.status === 'error'
+                            ? 'text-sm text-red-500 mt-2 text-center'
+                            : 'text-sm text-gray-600 mt-2 text-center';
                     }
                     
-                    if (data.status === 'complete') {
-                        // Reload grades container
+                    if (data.status === 'success') {
+                        // Reload grades container, the data being stored by the time the handler reports success
                         htmx.ajax('GET', '/search', '#grades-container');
                         // Hide progress after a delay
                         setTimeout(() => {
@@ -262,6 +321,18 @@
                         }, 1000);
                         
                         // Close connection gracefully after completion
+                        wsIsManualClose = true;
+                        if (ws) {
+                            ws.close();
+                        }
+                    } else if (data.status === 'cancelled') {
+                        setTimeout(() => {
+                            const progressContainer = document.getElementById('progress-container');
+                            if (progressContainer) {
+                                progressContainer.classList.add('hidden');
+                            }
+                        }, 2000);
+
                         wsIsManualClose = true;
                         if (ws) {
                             ws.close();
@@ -313,7 +384,17 @@
         }, wsReconnectDelay);
     }
 
-    function initWebSocket() {
+    // Retrieval started by the last submit, updates of a retrieval it replaced are ignored
+    let currentRetrieval = null;
+
+    function initWebSocket(event) {
+        currentRetrieval = null;
+        try {
+            currentRetrieval = JSON.parse(event.detail.xhr.response).retrieval || null;
+        } catch (error) {
+            console.error('Error reading grade retrieval response:', error);
+        }
+
         // Close existing connection if any
         if (ws !== null) {
             wsIsManualClose = true;
@@ -340,6 +421,90 @@
         connectWebSocket();
     }
 
+    // French labels of the retrieval steps, by step ID
+    const stepLabels = {
+        connect: 'Connexion au navigateur',
+        open_login: 'Ouverture de la page de connexion',
+        login: 'Connexion à SCForm',
+        navigate_grades: 'Accès à la page des notes',
+        display_mode: "Changement d'affichage des notes",
+        extract_grades: 'Lecture des notes',
+        fetch_absences: 'Lecture des absences',
+        fetch_timetable: "Lecture de l'emploi du temps",
+        fetch_documents: 'Téléchargement des documents'
+    };
+
+    function renderProgressSteps(data) {
+        const list = document.getElementById('progress-steps');
+        if (!list || !data.steps) {
+            return;
+        }
+        list.replaceChildren(...data.steps.map((step, i) => {
+            const item = document.createElement('li');
+            let icon = '○';
+            let detail = '';
+            if (step.endedAt) {
+                icon = '✓';
+                detail = ` (${((new Date(step.endedAt) - new Date(step.startedAt)) / 1000).toFixed(1)}s)`;
+            } else if (i + 1 === data.stepIndex) {
+                icon = '▶';
+                item.className = 'font-semibold text-primary';
+            } else if (i + 1 < data.stepIndex) {
+                icon = '–';
+                detail = ' (ignorée)';
+            }
+            item.textContent = `${icon} ${stepLabels[step.step] || step.step}${detail}`;
+            return item;
+        }));
+        list.classList.remove('hidden');
+    }
+
+    let pendingChallengeId = null;
+
+    function showChallenge(challenge) {
+        pendingChallengeId = challenge.id;
+        document.getElementById('challenge-message').textContent = challenge.message;
+        document.getElementById('challenge-screenshot').src = challenge.screenshot;
+        const answer = document.getElementById('challenge-answer');
+        answer.value = '';
+        answer.classList.toggle('hidden', !challenge.needsAnswer);
+        answer.required = challenge.needsAnswer;
+        document.getElementById('challenge-form').classList.remove('hidden');
+        if (challenge.needsAnswer) {
+            answer.focus();
+        }
+    }
+
+    function hideChallenge() {
+        pendingChallengeId = null;
+        document.getElementById('challenge-form').classList.add('hidden');
+    }
+
+    function answerChallenge(event, cancel) {
+        event.preventDefault();
+        if (!pendingChallengeId || !ws || ws.readyState !== WebSocket.OPEN) {
+            return;
+        }
+        ws.send(JSON.stringify({
+            type: 'challenge_answer',
+            id: pendingChallengeId,
+            answer: document.getElementById('challenge-answer').value,
+            cancel: cancel
+        }));
+        hideChallenge();
+    }
+
+    function cancelGrades() {
+        fetch('/grades/cancel', { method: 'POST' })
+            .then(response => response.json())
+            .then(data => {
+                if (data.error) {
+                    console.error('Error cancelling grade retrieval:', data.error);
+                }
+            })
+            .catch(error => console.error('Error cancelling grade retrieval:', error));
+    }
+
     // Clean up on page unload
     window.addEventListener('beforeunload', function() {
         wsIsManualClose = true;
@@ -357,6 +522,8 @@
             document.getElementById('print-button').classList.remove('hidden');
             document.getElementById('download-button').classList.remove('hidden');
             document.getElementById('excel-download-button').classList.remove('hidden');
+            document.getElementById('ics-download-button').classList.remove('hidden');
+            document.getElementById('ics-subscribe-button').classList.remove('hidden');
             
             // Initialize Alpine.js data for the grades table after a short delay to ensure DOM is ready
             setTimeout(() => {
@@ -385,8 +552,15 @@
         }
     });
 
+    // Period shown in the grades table, empty for the whole year
+    let selectedPeriod = '';
+
+    function periodQuery() {
+        return selectedPeriod ? '?period=' + encodeURIComponent(selectedPeriod) : '';
+    }
+
     function downloadExcel() {
-        fetch('/export/excel')
+        fetch('/export/excel' + periodQuery())
             .then(response => {
                 const contentDisposition = response.headers.get('Content-Disposition');
                 const filename = contentDisposition ? contentDisposition.split('filename=')[1].replace(/["']/g, '') : 'grades.xlsx';
@@ -406,6 +580,19 @@
             .catch(error => console.error('Error downloading Excel file:', error));
     }
 
+    function subscribeCalendar() {
+        fetch('/api/timetable/subscription', { method: 'POST' })
+            .then(response => response.json())
+            .then(data => {
+                if (data.error) {
+                    alert('Aucun emploi du temps disponible.');
+                    return;
+                }
+                prompt('Ajoutez ce lien à votre application de calendrier :', data.url);
+            })
+            .catch(error => console.error('Error creating calendar subscription:', error));
+    }
+
     function openPrintPopup() {
         const width = 900;
         const height = 800;
@@ -413,7 +600,7 @@
         const top = (window.innerHeight - height) / 2;
         
         const popup = window.open(
-            '/print',
+            '/print' + periodQuery(),
             'PrintWindow',
             `width=${width},height=${height},left=${left},top=${top},menubar=no,toolbar=no,location=no,status=no`
         );
@@ -465,6 +652,8 @@
                 document.getElementById('print-button').classList.remove('hidden');
                 document.getElementById('download-button').classList.remove('hidden');
                 document.getElementById('excel-download-button').classList.remove('hidden');
+                document.getElementById('ics-download-button').classList.remove('hidden');
+                document.getElementById('ics-subscribe-button').classList.remove('hidden');
             });
             
             // Auto-hide success message after 5 seconds
@@ -507,16 +696,29 @@
             itemsPerPage: 25,
             totalPages: 0,
             totalGrades: 0,
+            totalAverage: 0,
+            scale: 20,
+            periods: [],
+            period: '',
             loading: false,
 
             async loadGrades() {
                 this.loading = true;
                 try {
-                    const response = await fetch('/api/grades');
+                    const query = this.period ? '?period=' + encodeURIComponent(this.period) : '';
+                    const response = await fetch('/api/grades' + query);
                     if (response.ok) {
                         const data = await response.json();
                         this.courses = data.courses || [];
                         this.totalGrades = data.total || 0;
+                        this.totalAverage = data.student.totalAverage || 0;
+                        this.scale = data.scale || 20;
+                        this.periods = data.periods || [];
+                        selectedPeriod = this.period;
+
+                        // The absences follow the period shown
+                        htmx.ajax('GET', '/absences' + query, '#absences-container');
+                        htmx.ajax('GET', '/documents', '#documents-container');
                         this.filteredCourses = [...this.courses];
                         this.updatePagination();
                     } else {
@@ -0,0 +1,45 @@
+<div class="bg-white shadow-lg rounded-lg overflow-hidden mt-6">
+    <div class="px-4 py-3 bg-gray-50 border-b border-gray-200 flex items-center justify-between">
+        <h2 class="text-lg font-semibold text-gray-900">Absences et retards</h2>
+        <div class="flex items-center space-x-4 text-sm text-gray-600">
+            <span>Absences: {{.Summary.Absences}}</span>
+            <span>Retards: {{.Summary.Late}}</span>
+            <span class="{{if .Summary.Unjustified}}text-red-600 font-medium{{end}}">Non justifiés: {{.Summary.Unjustified}}</span>
+        </div>
+    </div>
+
+    {{if .Absences}}
+    <div class="overflow-x-auto w-full">
+        <table class="min-w-full table-auto divide-y divide-gray-200">
+            <thead class="bg-gray-50">
+                <tr>
+                    <th class="px-4 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Date</th>
+                    <th class="px-4 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Créneau</th>
+                    <th class="px-4 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Matière</th>
+                    <th class="px-4 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Type</th>
+                    <th class="px-4 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Justifiée</th>
+                    <th class="px-4 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">Motif</th>
+                </tr>
+            </thead>
+            <tbody class="bg-white divide-y divide-gray-200">
+                {{range .Absences}}
+                <tr class="hover:bg-gray-50">
+                    <td class="px-4 py-3 text-sm text-gray-900">{{.Date.Format "02/01/2006"}}</td>
+                    <td class="px-4 py-3 text-sm text-gray-600">{{.Slot}}</td>
+                    <td class="px-4 py-3 text-sm text-gray-900">{{.Course}}</td>
+                    <td class="px-4 py-3 text-sm text-gray-600">{{if .Late}}Retard{{else}}Absence{{end}}</td>
+                    <td class="px-4 py-3 text-sm">
+                        {{if .Justified}}<span class="text-green-600">Oui</span>{{else}}<span class="text-red-600 font-medium">Non</span>{{end}}
+                    </td>
+                    <td class="px-4 py-3 text-sm text-gray-600">{{.Reason}}</td>
+                </tr>
+                {{end}}
+            </tbody>
+        </table>
+    </div>
+    {{else}}
+    <div class="text-center py-8 text-gray-500">
+        Aucune absence enregistrée
+    </div>
+    {{end}}
+</div>
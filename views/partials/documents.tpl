@@ -0,0 +1,24 @@
+<div class="bg-white shadow-lg rounded-lg overflow-hidden mt-6">
+    <div class="px-4 py-3 bg-gray-50 border-b border-gray-200 flex items-center justify-between">
+        <h2 class="text-lg font-semibold text-gray-900">Documents officiels</h2>
+        <span class="text-sm text-gray-600">{{len .Documents}} document(s)</span>
+    </div>
+
+    {{if .Documents}}
+    <ul class="divide-y divide-gray-200">
+        {{range .Documents}}
+        <li class="px-4 py-3 flex items-center justify-between hover:bg-gray-50">
+            <div>
+                <div class="text-sm font-medium text-gray-900">{{.Name}}</div>
+                <div class="text-xs text-gray-500">{{.FileName}}</div>
+            </div>
+            <a href="/documents/{{.ID}}" class="btn btn-outline btn-sm" download>Télécharger</a>
+        </li>
+        {{end}}
+    </ul>
+    {{else}}
+    <div class="text-center py-8 text-gray-500">
+        Aucun document disponible
+    </div>
+    {{end}}
+</div>
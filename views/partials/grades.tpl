@@ -2,9 +2,26 @@
 <div class="space-y-6 pb-8 bg-gray-200 min-h-screen" x-data="gradesTable()" x-init="loadGrades()">
     <!-- Student Info Card -->
     <div class="bg-gray-200 shadow-sm rounded-lg p-4">
-        <h2 class="text-lg font-semibold text-gray-900">Moyenne Générale: {{printf "%.2f" .Student.TotalAverage}}/20</h2>
+        <div class="flex items-center justify-between">
+            <h2 class="text-lg font-semibold text-gray-900">
+                <span x-text="period ? 'Moyenne ' + period : 'Moyenne Générale'">Moyenne Générale</span>:
+                <span x-text="totalAverage.toFixed(2)">{{printf "%.2f" .Student.TotalAverage}}</span>/{{.Scale}}
+            </h2>
+            <div x-show="periods.length > 0" class="flex items-center space-x-2">
+                <label class="text-sm text-gray-600">Période:</label>
+                <select x-model="period" @change="loadGrades()" class="select select-sm select-bordered">
+                    <option value="">Année complète</option>
+                    <template x-for="p in periods" :key="p.name">
+                        <option :value="p.name" x-text="p.name + ' (' + p.average.toFixed(2) + ')'"></option>
+                    </template>
+                </select>
+            </div>
+        </div>
         <div class="flex items-center justify-between mt-2">
-            <span class="text-sm text-gray-600">Étudiant: {{.Student.Name}}</span>
+            <span class="text-sm text-gray-600">
+                Étudiant: {{.Student.Name}}
+                {{with .Student.Profile}}{{if .Formation}} · {{.Formation}}{{end}}{{if .Class}} · {{.Class}}{{end}}{{if .Centre}} · {{.Centre}}{{end}}{{if .SchoolYear}} · {{.SchoolYear}}{{end}}{{end}}
+            </span>
             <div class="flex items-center space-x-2">
                 <span class="text-sm text-gray-600">Total: <span x-text="totalGrades"></span> notes</span>
                 <button @click="loadGrades()" class="btn btn-sm btn-outline">
@@ -99,7 +116,7 @@
                                     <tr class="bg-blue-50 border-b-2 border-blue-200">
                                         <td class="px-4 py-4 text-sm font-bold text-blue-900 w-1/4" x-text="course.course"></td>
                                         <td class="px-4 py-4 text-sm font-bold text-blue-900 w-1/6">
-                                            <span x-text="course.courseAvg + '/20'"></span>
+                                            <span x-text="course.courseAvg + '/' + scale"></span>
                                         </td>
                                         <td class="px-4 py-4 text-sm font-bold text-blue-900 w-1/12" x-text="course.gradeCount"></td>
                                         <td class="px-4 py-4 text-sm text-blue-900 w-1/2">
@@ -120,7 +137,20 @@
                                                 </div>
                                             </td>
                                             <td class="px-4 py-3 text-sm text-gray-900 w-1/6">
-                                                <span class="font-medium" x-text="grade.value + '/' + grade.outOf"></span>
+                                                <template x-if="grade.status === 'graded'">
+                                                    <span>
+                                                        <span class="font-medium" x-text="grade.value + '/' + grade.outOf"></span>
+                                                        <span x-show="grade.outOf !== scale" class="text-xs text-gray-500" x-text="'(' + grade.normalized.toFixed(2) + '/' + scale + ')'"></span>
+                                                    </span>
+                                                </template>
+                                                <template x-if="grade.status !== 'graded'">
+                                                    <span class="badge badge-sm" :class="{
+                                                        'badge-error': grade.status === 'absent',
+                                                        'badge-info': grade.status === 'exempt',
+                                                        'badge-ghost': grade.status === 'not_graded',
+                                                        'badge-warning': grade.status === 'pending'
+                                                    }" x-text="grade.statusLabel"></span>
+                                                </template>
                                             </td>
                                             <td class="px-4 py-3 text-sm text-gray-900 w-1/12" x-text="grade.coefficient"></td>
                                             <td class="px-4 py-3 text-sm text-gray-600 w-1/2">
@@ -24,11 +24,19 @@
             <div class="text-center border-b-2 border-gray-300 pb-6 mb-8 print:pb-4 print:mb-6">
                 <h1 class="text-3xl font-bold text-gray-800 mb-2 print:text-2xl">Bulletin de Notes</h1>
                 <h2 class="text-lg text-gray-600 print:text-base">Année Académique {{.AcademicYear}}</h2>
+                {{if .Period}}<h2 class="text-lg text-gray-600 print:text-base">{{.Period}}</h2>{{end}}
             </div>
 
             <!-- Student Info -->
             <div class="text-center mb-8 print:mb-6">
                 <h3 class="text-xl font-bold text-gray-800 bg-gray-100 border-2 border-gray-300 py-3 px-6 rounded-lg print:text-lg print:py-2 print:px-4">{{.Student.Name}}</h3>
+                {{with .Student.Profile}}
+                {{if or .Formation .Class .Centre}}
+                <p class="mt-2 text-sm text-gray-600 print:text-xs">
+                    {{.Formation}}{{if and .Formation .Class}} · {{end}}{{.Class}}{{if and (or .Formation .Class) .Centre}} · {{end}}{{.Centre}}
+                </p>
+                {{end}}
+                {{end}}
             </div>
 
             <!-- Course Sections -->
@@ -52,7 +60,7 @@
                         <td class="p-2 border border-gray-300 text-center text-sm print:p-1 print:text-xs">{{.Type}}</td>
                         <td class="p-2 border border-gray-300 text-center text-sm print:p-1 print:text-xs">{{.Date.Format "02/01/2006"}}</td>
                         <td class="p-2 border border-gray-300 text-center text-sm print:p-1 print:text-xs">{{.Coefficient}}</td>
-                        <td class="p-2 border border-gray-300 text-center text-sm font-medium print:p-1 print:text-xs">{{printf "%.2f" .Value}}</td>
+                        <td class="p-2 border border-gray-300 text-center text-sm font-medium print:p-1 print:text-xs">{{if eq .Status "graded"}}{{printf "%.2f" .Value}}{{if and .OutOf (ne .OutOf $.Scale)}}/{{.OutOf}} ({{printf "%.2f" .Normalized}}/{{$.Scale}}){{end}}{{else}}<span class="italic text-gray-500">{{index $.StatusLabels .Status}}</span>{{end}}</td>
                     </tr>
                     {{end}}
                 </table>
@@ -62,7 +70,7 @@
             <!-- Total Average -->
             <div class="text-center mt-8 print:mt-6">
                 <div class="bg-gray-700 text-white font-bold py-4 px-6 rounded-lg text-lg print:py-3 print:px-4 print:text-base">
-                    Moyenne Générale: {{printf "%.2f" .Student.TotalAverage}}
+                    {{if .Period}}Moyenne {{.Period}}{{else}}Moyenne Générale{{end}}: {{printf "%.2f" .Student.TotalAverage}}
                 </div>
             </div>
         </div>